@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// thousandsSeparators may be used between digit groups of an amount, e.g. "1 200" typed with a non-breaking,
+// narrow non-breaking or thin space. A regular space is not one of them: "кофе 150 200" are two amounts.
+var thousandsSeparators = []rune{'\u00a0', '\u202f', '\u2009', '\''}
+
+// amountMultipliers are suffixes like in "1.5к" or "2тыс"
+var amountMultipliers = map[string]float64{"к": 1000, "k": 1000, "тыс": 1000, "тыс.": 1000}
+
+// wordMultipliers may follow the amount as a separate word like in "2 тыс", a bare "к" is a preposition
+var wordMultipliers = map[string]float64{"тыс": 1000, "тыс.": 1000}
+
+// roubleSuffixes are written right after the amount, e.g. "300р" or "300руб."
+var roubleSuffixes = []string{"рублей", "рубля", "рубль", "руб.", "руб", "р.", "р"}
+
+// AmountError is returned for a word which looks like an amount but can not be parsed
+type AmountError struct {
+	Word string
+}
+
+func (ae *AmountError) Error() string {
+	return fmt.Sprintf("could not parse amount %q", ae.Word)
+}
+
+// amount is a sum of money as it was written in the message
+type amount struct {
+	value    float64
+	currency string
+}
+
+// splitWords splits the message into words, thousands separators followed by a group of three digits are removed first.
+// A comma between a word and a number separates them, e.g. "кофе,150".
+func splitWords(input string) []string {
+	runes := []rune(input)
+	var joined strings.Builder
+	for i, r := range runes {
+		if isThousandsSeparator(r) && i > 0 && unicode.IsDigit(runes[i-1]) && isThousandsGroup(runes[i+1:]) {
+			continue
+		}
+		if r == ',' && i > 0 && i+1 < len(runes) && unicode.IsLetter(runes[i-1]) && unicode.IsDigit(runes[i+1]) {
+			joined.WriteRune(' ')
+			continue
+		}
+		joined.WriteRune(r)
+	}
+	return strings.Fields(joined.String())
+}
+
+// isThousandsGroup reports whether the text starts with exactly three digits, e.g. "200" or "500,50"
+func isThousandsGroup(runes []rune) bool {
+	digits := 0
+	for digits < len(runes) && unicode.IsDigit(runes[digits]) {
+		digits++
+	}
+	return digits == 3
+}
+
+func isThousandsSeparator(r rune) bool {
+	for _, separator := range thousandsSeparators {
+		if r == separator {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAmount parses a word like "120,50", "1.5к", "$25" or "300руб".
+// ok is false when the word is not an amount at all.
+func parseAmount(word string) (result amount, ok bool, err error) {
+	core, currency := splitCurrencySymbol(word)
+	lower := strings.ToLower(core)
+	if currency == "" {
+		for _, suffix := range roubleSuffixes {
+			if strings.HasSuffix(lower, suffix) && len(lower) > len(suffix) {
+				lower, currency = strings.TrimSuffix(lower, suffix), roubles
+				break
+			}
+		}
+	}
+	multiplier := 1.0
+	for suffix, value := range amountMultipliers {
+		if strings.HasSuffix(lower, suffix) && len(lower) > len(suffix) {
+			lower, multiplier = strings.TrimSuffix(lower, suffix), value
+			break
+		}
+	}
+	if !looksLikeNumber(lower) {
+		return amount{}, false, nil
+	}
+	value, err := parseLocaleNumber(lower)
+	if err != nil {
+		return amount{}, false, &AmountError{Word: word}
+	}
+	return amount{value: value * multiplier, currency: currency}, true, nil
+}
+
+// looksLikeNumber reports whether the word has digits and nothing but digits and separators
+func looksLikeNumber(word string) bool {
+	word = strings.TrimPrefix(strings.TrimPrefix(word, "-"), "+")
+	hasDigit := false
+	for _, r := range word {
+		switch {
+		case r >= '0' && r <= '9':
+			hasDigit = true
+		case r == '.' || r == ',':
+		default:
+			return false
+		}
+	}
+	return hasDigit
+}
+
+// parseLocaleNumber understands both decimal commas and points.
+// When both are present the last one is the decimal separator, e.g. "1.200,50" and "1,200.50".
+// A single separator is decimal, several equal ones separate thousands, e.g. "1.200.000".
+func parseLocaleNumber(word string) (float64, error) {
+	commas, points := strings.Count(word, ","), strings.Count(word, ".")
+	var decimal, thousands string
+	switch {
+	case commas > 0 && points > 0:
+		decimal, thousands = ".", ","
+		if strings.LastIndex(word, ",") > strings.LastIndex(word, ".") {
+			decimal, thousands = ",", "."
+		}
+		if strings.Count(word, decimal) > 1 {
+			return 0, fmt.Errorf("several decimal separators in %s", word)
+		}
+	case commas > 1:
+		thousands = ","
+	case points > 1:
+		thousands = "."
+	case commas == 1:
+		decimal = ","
+	case points == 1:
+		decimal = "."
+	}
+	integer, fraction := word, ""
+	if decimal != "" {
+		i := strings.LastIndex(word, decimal)
+		integer, fraction = word[:i], word[i+1:]
+		if fraction == "" {
+			return 0, fmt.Errorf("no digits after the decimal separator in %s", word)
+		}
+	}
+	if thousands != "" {
+		groups := strings.Split(integer, thousands)
+		for i, group := range groups {
+			if (i > 0 && len(group) != 3) || (i == 0 && (len(group) == 0 || len(group) > 3)) {
+				return 0, fmt.Errorf("invalid digit groups in %s", word)
+			}
+		}
+		integer = strings.Join(groups, "")
+	}
+	if fraction != "" {
+		integer += "." + fraction
+	}
+	return strconv.ParseFloat(integer, 64)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		input string
+		words []string
+	}{
+		{"кофе 150 200", []string{"кофе", "150", "200"}},
+		{"метро 62 120", []string{"метро", "62", "120"}},
+		{"ноутбук 1\u00a0200", []string{"ноутбук", "1200"}},
+		{"ноутбук 1\u202f200\u202f000", []string{"ноутбук", "1200000"}},
+		{"ноутбук 1\u2009200", []string{"ноутбук", "1200"}},
+		{"кофе,150", []string{"кофе", "150"}},
+		{"кофе,чай 150", []string{"кофе,чай", "150"}},
+		{"ноутбук 1'200,50", []string{"ноутбук", "1200,50"}},
+		{"кофе 1 20", []string{"кофе", "1", "20"}},
+	}
+	for _, test := range tests {
+		if words := splitWords(test.input); !reflect.DeepEqual(words, test.words) {
+			t.Errorf("splitWords(%q) = %q, want %q", test.input, words, test.words)
+		}
+	}
+}
+
+func TestParseInput(t *testing.T) {
+	tm := NewTableManagement(nil, &ManagementProperties{})
+	tests := []struct {
+		input       string
+		description string
+		sum         float64
+	}{
+		{"кофе 150 200", "кофе", 350},
+		{"метро 62 120", "метро", 182},
+		{"ноутбук 1\u00a0200", "ноутбук", 1200},
+		{"ноутбук 1\u2009200", "ноутбук", 1200},
+		{"кофе,150", "кофе", 150},
+		{"обед 120,50", "обед", 120.5},
+		{"такси 1.5к", "такси", 1500},
+		{"такси 2 тыс", "такси", 2000},
+		{"такси 300 к вокзалу", "такси, к, вокзалу", 300},
+		{"такси 2 к 3", "такси, к", 5},
+		{"такси 2 k", "такси, k", 2},
+		{"обед 300р", "обед", 300},
+		{"кино драма 500", "кино, драма", 500},
+		{"мясо 2 фунта 500", "мясо, 2, фунта", 500},
+	}
+	for _, test := range tests {
+		description, sum, err := tm.parseInput(test.input)
+		if err != nil {
+			t.Errorf("parseInput(%q) error: %v", test.input, err)
+			continue
+		}
+		if description != test.description || sum != test.sum {
+			t.Errorf("parseInput(%q) = %q, %v, want %q, %v", test.input, description, sum, test.description, test.sum)
+		}
+	}
+}
+
+func TestParseInputCurrencies(t *testing.T) {
+	rates, err := ParseCurrencyRates([]byte(`<ValCurs Date="15.03.2026">` +
+		`<Valute><CharCode>USD</CharCode><Nominal>1</Nominal><Value>90,5</Value></Valute>` +
+		`<Valute><CharCode>AMD</CharCode><Nominal>100</Nominal><Value>20,5</Value></Valute></ValCurs>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := NewTableManagement(nil, &ManagementProperties{Rates: rates})
+	tests := []struct {
+		input       string
+		description string
+		sum         float64
+	}{
+		{"usd 10 такси", "такси (10 USD)", 905},
+		{"$10 такси", "такси (10 USD)", 905},
+		{"такси 10 долларов", "такси (10 USD)", 905},
+		{"кино 500 драм", "кино (500 AMD)", 102.5},
+		{"кино драма 500", "кино, драма", 500},
+	}
+	for _, test := range tests {
+		description, sum, err := tm.parseInput(test.input)
+		if err != nil {
+			t.Errorf("parseInput(%q) error: %v", test.input, err)
+			continue
+		}
+		if description != test.description || sum != test.sum {
+			t.Errorf("parseInput(%q) = %q, %v, want %q, %v", test.input, description, sum, test.description, test.sum)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Author modes, the short name of the author is put before or after the description
+const (
+	authorPrefix = "prefix"
+	authorSuffix = "suffix"
+)
+
+// AuthorTotals keeps how much every author of a chat spent per month in a file, empty path keeps them in memory only.
+// The sheet merges the expenses of a day into one cell, so the totals per person cannot be read back from it.
+type AuthorTotals struct {
+	path  string
+	mu    sync.Mutex
+	chats map[string]map[string]map[string]float64
+}
+
+// AuthorTotal is the sum spent by an author
+type AuthorTotal struct {
+	Author string
+	Sum    float64
+}
+
+// NewAuthorTotals creates new AuthorTotals instant and loads the totals saved before
+func NewAuthorTotals(path string) (*AuthorTotals, error) {
+	at := &AuthorTotals{path: path, chats: make(map[string]map[string]map[string]float64)}
+	if path == "" {
+		return at, nil
+	}
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return at, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bytes, &at.chats); err != nil {
+		return nil, fmt.Errorf("could not parse author totals %s: %v", path, err)
+	}
+	return at, nil
+}
+
+// Add counts the expenses of the author in the chat in the months of their dates
+func (at *AuthorTotals) Add(chatID int64, author string, expenses []*Expense) {
+	if author == "" || len(expenses) == 0 {
+		return
+	}
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	key := strconv.FormatInt(chatID, 10)
+	months, ok := at.chats[key]
+	if !ok {
+		months = make(map[string]map[string]float64)
+		at.chats[key] = months
+	}
+	for _, expense := range expenses {
+		month := monthOf(expense.Date)
+		if _, ok := months[month]; !ok {
+			months[month] = make(map[string]float64)
+		}
+		months[month][author] = math.Round((months[month][author]+expense.Sum)*100) / 100
+	}
+	at.save()
+}
+
+// Month returns the totals of the chat in the month, the largest first
+func (at *AuthorTotals) Month(chatID int64, year int, month time.Month) []AuthorTotal {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	var totals []AuthorTotal
+	for author, sum := range at.chats[strconv.FormatInt(chatID, 10)][monthOf(time.Date(year, month, 1, 0, 0, 0, 0, time.UTC))] {
+		totals = append(totals, AuthorTotal{Author: author, Sum: sum})
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].Sum != totals[j].Sum {
+			return totals[i].Sum > totals[j].Sum
+		}
+		return totals[i].Author < totals[j].Author
+	})
+	return totals
+}
+
+func (at *AuthorTotals) save() {
+	if at.path == "" {
+		return
+	}
+	bytes, err := json.Marshal(at.chats)
+	if err == nil {
+		err = writeFileAtomically(at.path, bytes)
+	}
+	if err != nil {
+		log.Printf("Could not save author totals: %v", err)
+	}
+}
+
+// authorName returns the configured short name of the user, the first name when there is none
+func (tm *TableManagement) authorName(user *tgbotapi.User) string {
+	if user == nil {
+		return ""
+	}
+	if name, ok := tm.authors[user.ID]; ok {
+		return name
+	}
+	if user.FirstName != "" {
+		return user.FirstName
+	}
+	return user.UserName
+}
+
+// attribute puts the short name of the author into the description when the author mode is on,
+// e.g. "Аня: кофе" or "кофе (Аня)"
+func (tm *TableManagement) attribute(description string, author string) string {
+	if author == "" {
+		return description
+	}
+	switch {
+	case tm.authorMode == "":
+		return description
+	case description == "":
+		return author
+	case tm.authorMode == authorPrefix:
+		return author + ": " + description
+	default:
+		return description + " (" + author + ")"
+	}
+}
+
+// parseAuthorNames parses a comma separated list of Telegram user IDs with their short names, e.g. "123=Аня,456=Петя"
+func parseAuthorNames(list string) (map[int]string, error) {
+	names := make(map[int]string)
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("%q is not id=name", field)
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, err
+		}
+		names[id] = strings.TrimSpace(parts[1])
+	}
+	return names, nil
+}
+
+// monthNumber returns the month of the sheet name
+func monthNumber(name string) time.Month {
+	for month := time.January; month <= time.December; month++ {
+		if sheetName(month) == name {
+			return month
+		}
+	}
+	return 0
+}
+
+// whoCommand totals the expenses of the month per author
+func whoCommand(tm *TableManagement, request *CommandRequest) (string, error) {
+	l := request.Localizer
+	year, month, invalid := tm.parsePeriod(request.Args)
+	if invalid != "" {
+		return l.Text("unknownPeriod", invalid), nil
+	}
+	totals := tm.authorTotals.Month(request.ChatID, year, monthNumber(month))
+	if len(totals) == 0 {
+		return l.Text("noExpenses", fmt.Sprintf("%s %d", month, year)), nil
+	}
+	lines := []string{l.Text("whoTotals", fmt.Sprintf("%s %d", month, year))}
+	for _, total := range totals {
+		lines = append(lines, total.Author+" — "+l.Roubles(strconv.FormatFloat(total.Sum, 'f', -1, 64)))
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// ChatSettings are the preferences of a chat
+type ChatSettings struct {
+	Language  string `json:"language,omitempty"`
+	Timezone  string `json:"timezone,omitempty"`
+	DayEndsAt *int   `json:"day_ends_at,omitempty"`
+}
+
+// ChatSettingsStore keeps the settings of every chat in a file, empty path keeps them in memory only
+type ChatSettingsStore struct {
+	path  string
+	mu    sync.Mutex
+	chats map[string]*ChatSettings
+}
+
+// NewChatSettingsStore creates new ChatSettingsStore instant and loads the settings saved before
+func NewChatSettingsStore(path string) (*ChatSettingsStore, error) {
+	cs := &ChatSettingsStore{path: path, chats: make(map[string]*ChatSettings)}
+	if path == "" {
+		return cs, nil
+	}
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bytes, &cs.chats); err != nil {
+		return nil, fmt.Errorf("could not parse chat settings %s: %v", path, err)
+	}
+	return cs, nil
+}
+
+// Get returns a copy of the chat settings, zero settings for an unknown chat
+func (cs *ChatSettingsStore) Get(chatID int64) ChatSettings {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if settings, ok := cs.chats[strconv.FormatInt(chatID, 10)]; ok {
+		return *settings
+	}
+	return ChatSettings{}
+}
+
+// Update changes the chat settings and persists them
+func (cs *ChatSettingsStore) Update(chatID int64, change func(settings *ChatSettings)) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	key := strconv.FormatInt(chatID, 10)
+	settings, ok := cs.chats[key]
+	if !ok {
+		settings = &ChatSettings{}
+		cs.chats[key] = settings
+	}
+	change(settings)
+	if cs.path == "" {
+		return
+	}
+	bytes, err := json.Marshal(cs.chats)
+	if err == nil {
+		err = writeFileAtomically(cs.path, bytes)
+	}
+	if err != nil {
+		log.Printf("Could not save chat settings: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const cliUsage = `Usage: tinkoff-table-bot [command] [flags] [arguments]
+
+Commands:
+  (none)              run the Telegram bot
+  auth                authorize the bot in Google and save the token
+  add "кофе 150"      record an expense
+  balance db|mb|ma    show daily balance, monthly balance or accumulation
+  history [месяц]     show expenses of the current or the given month
+  repl                read expenses and /commands from the terminal
+  replay [-update] [flags] recording.jsonl golden.txt
+                      replay a recording made with RECORD_FILE and compare with the golden file`
+
+// terminalChatID is used as the chat of the updates built from the terminal input
+const terminalChatID = 0
+
+// runCLI executes a subcommand against the spreadsheet without Telegram
+func runCLI(command string, args []string) {
+	config, err := LoadConfiguration(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := config.ValidateSheet(); err != nil {
+		log.Fatal(err)
+	}
+	tm := newTableManagement(config, nil)
+	// There is no keyboard in the terminal, the amounts are written without a confirmation
+	tm.pending = NewPendingExpenses(0, 0)
+	// The terminal user has no Telegram ID, the one who runs the bot owns the sheet anyway
+	tm.owners = nil
+	args = config.Args()
+	switch command {
+	case "add":
+		if len(args) == 0 {
+			log.Fatal("Nothing to add, usage: add \"кофе 150\"")
+		}
+		fmt.Println(replyToTerminal(tm, strings.Join(args, " ")))
+	case "balance":
+		if len(args) != 1 {
+			log.Fatal("Usage: balance db|mb|ma")
+		}
+		fmt.Println(replyToTerminal(tm, "/"+args[0]))
+	case "history":
+		fmt.Println(replyToTerminal(tm, strings.Join(append([]string{"/history"}, args...), " ")))
+	case "repl":
+		runREPL(tm, os.Stdin, os.Stdout)
+	}
+}
+
+func runREPL(tm *TableManagement, in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+	fmt.Fprint(out, "> ")
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case "":
+		case "exit", "quit":
+			return
+		default:
+			fmt.Fprintln(out, replyToTerminal(tm, line))
+		}
+		fmt.Fprint(out, "> ")
+	}
+}
+
+// replyToTerminal passes the text through the same processing as Telegram messages
+func replyToTerminal(tm *TableManagement, text string) string {
+	return reply(tm, newTerminalUpdate(text)).Text
+}
+
+func newTerminalUpdate(text string) *tgbotapi.Update {
+	message := &tgbotapi.Message{
+		Text: text,
+		Chat: &tgbotapi.Chat{ID: terminalChatID, Type: "private"},
+		From: &tgbotapi.User{UserName: os.Getenv("USER")},
+	}
+	if strings.HasPrefix(text, "/") {
+		command := strings.SplitN(text, " ", 2)[0]
+		message.Entities = &[]tgbotapi.MessageEntity{{
+			Type:   "bot_command",
+			Offset: 0,
+			Length: len(utf16.Encode([]rune(command))),
+		}}
+	}
+	return &tgbotapi.Update{Message: message}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Permission tells who may run a command
+type Permission int
+
+const (
+	// PermitEveryone allows the command in any chat
+	PermitEveryone Permission = iota
+	// PermitOwners allows the command to the users from OWNER_USERS, everyone when the list is empty
+	PermitOwners
+)
+
+// Command is a bot command with its handler
+type Command struct {
+	Name    string
+	Aliases []string
+	// Description and Usage are the keys of the message catalogue, the usage describes the arguments
+	Description string
+	Usage       string
+	MinArgs     int
+	MaxArgs     int
+	Permission  Permission
+	Handler     func(tm *TableManagement, request *CommandRequest) (string, error)
+}
+
+// CommandRequest is a parsed command message
+type CommandRequest struct {
+	ChatID    int64
+	User      *tgbotapi.User
+	Name      string
+	Args      []string
+	Localizer *Localizer
+}
+
+// CommandRouter finds the command of a message by its name or alias and runs it
+type CommandRouter struct {
+	// BotName is the username of the bot, commands addressed to other bots like "/db@otherbot" are ignored
+	BotName  string
+	commands []*Command
+	byName   map[string]*Command
+}
+
+// NewCommandRouter creates new CommandRouter instant with the commands of the bot
+func NewCommandRouter() *CommandRouter {
+	cr := &CommandRouter{byName: make(map[string]*Command)}
+	cr.Register(&Command{
+		Name:        "start",
+		Description: "startCommand",
+		Handler: func(tm *TableManagement, request *CommandRequest) (string, error) {
+			return request.Localizer.Text("start", cr.Help(tm, request)), nil
+		},
+	})
+	cr.Register(&Command{
+		Name:        "help",
+		Aliases:     []string{"помощь"},
+		Description: "helpCommand",
+		Handler: func(tm *TableManagement, request *CommandRequest) (string, error) {
+			return cr.Help(tm, request), nil
+		},
+	})
+	cr.Register(&Command{
+		Name:        "db",
+		Aliases:     []string{"остаток"},
+		Description: "dbCommand",
+		Permission:  PermitOwners,
+		Handler: func(tm *TableManagement, request *CommandRequest) (string, error) {
+			return tm.localizedBalance(request.Localizer, "db", "dailyBalance")
+		},
+	})
+	cr.Register(&Command{
+		Name:        "mb",
+		Aliases:     []string{"месяц"},
+		Description: "mbCommand",
+		Usage:       "periodUsage",
+		MaxArgs:     2,
+		Permission:  PermitOwners,
+		Handler: func(tm *TableManagement, request *CommandRequest) (string, error) {
+			year, month, invalid := tm.parsePeriod(request.Args)
+			if invalid != "" {
+				return request.Localizer.Text("unknownPeriod", invalid), nil
+			}
+			balance, err := tm.getMonthlyBalance(year, month)
+			return request.Localizer.Text("monthlyBalance", request.Localizer.Roubles(balance)), err
+		},
+	})
+	cr.Register(&Command{
+		Name:        "ma",
+		Aliases:     []string{"накопления"},
+		Description: "maCommand",
+		Usage:       "periodUsage",
+		MaxArgs:     2,
+		Permission:  PermitOwners,
+		Handler: func(tm *TableManagement, request *CommandRequest) (string, error) {
+			year, month, invalid := tm.parsePeriod(request.Args)
+			if invalid != "" {
+				return request.Localizer.Text("unknownPeriod", invalid), nil
+			}
+			accumulation, err := tm.getMonthlyAccumulation(year, month)
+			return request.Localizer.Text("accumulation", request.Localizer.Roubles(accumulation)), err
+		},
+	})
+	cr.Register(&Command{
+		Name:        "history",
+		Aliases:     []string{"история"},
+		Description: "historyCommand",
+		Usage:       "periodUsage",
+		MaxArgs:     2,
+		Permission:  PermitOwners,
+		Handler: func(tm *TableManagement, request *CommandRequest) (string, error) {
+			year, month, invalid := tm.parsePeriod(request.Args)
+			if invalid != "" {
+				return request.Localizer.Text("unknownPeriod", invalid), nil
+			}
+			history, err := tm.getHistory(year, month)
+			if err == nil && history == "" {
+				history = request.Localizer.Text("noExpenses", fmt.Sprintf("%s %d", month, year))
+			}
+			return history, err
+		},
+	})
+	cr.Register(&Command{
+		Name:        "language",
+		Aliases:     []string{"язык"},
+		Description: "languageCommand",
+		Usage:       "ru|en",
+		MinArgs:     1,
+		MaxArgs:     1,
+		Permission:  PermitOwners,
+		Handler: func(tm *TableManagement, request *CommandRequest) (string, error) {
+			language := strings.ToLower(request.Args[0])
+			if _, ok := messages[language]; !ok {
+				return request.Localizer.Text("unknownLanguage"), nil
+			}
+			tm.chats.Update(request.ChatID, func(settings *ChatSettings) { settings.Language = language })
+			return NewLocalizer(language).Text("language"), nil
+		},
+	})
+	cr.Register(&Command{
+		Name:        "timezone",
+		Aliases:     []string{"пояс"},
+		Description: "timezoneCommand",
+		Usage:       "Europe/Moscow",
+		MaxArgs:     1,
+		Permission:  PermitOwners,
+		Handler: func(tm *TableManagement, request *CommandRequest) (string, error) {
+			if len(request.Args) == 1 {
+				location, err := time.LoadLocation(request.Args[0])
+				if err != nil || request.Args[0] == "" || request.Args[0] == "Local" {
+					return request.Localizer.Text("unknownTimezone", request.Args[0]), nil
+				}
+				tm.chats.Update(request.ChatID, func(settings *ChatSettings) { settings.Timezone = location.String() })
+			}
+			location, dayEndsAt := tm.chatClock(request.ChatID)
+			return request.Localizer.Text("timezone", location, dayEndsAt), nil
+		},
+	})
+	cr.Register(&Command{
+		Name:        "dayend",
+		Aliases:     []string{"конецдня"},
+		Description: "dayEndCommand",
+		Usage:       "0-12",
+		MaxArgs:     1,
+		Permission:  PermitOwners,
+		Handler: func(tm *TableManagement, request *CommandRequest) (string, error) {
+			if len(request.Args) == 1 {
+				hour, err := strconv.Atoi(request.Args[0])
+				if err != nil || !validDayEnd(hour) {
+					return request.Localizer.Text("invalidDayEnd"), nil
+				}
+				tm.chats.Update(request.ChatID, func(settings *ChatSettings) { settings.DayEndsAt = &hour })
+			}
+			location, dayEndsAt := tm.chatClock(request.ChatID)
+			return request.Localizer.Text("timezone", location, dayEndsAt), nil
+		},
+	})
+	cr.Register(&Command{
+		Name:        "recurring",
+		Aliases:     []string{"регулярные"},
+		Description: "recurringCommand",
+		Usage:       "recurringUsage",
+		MinArgs:     1,
+		MaxArgs:     recurringMaxArgs,
+		Permission:  PermitOwners,
+		Handler:     recurringCommand,
+	})
+	cr.Register(&Command{
+		Name:        "settle",
+		Aliases:     []string{"долги"},
+		Description: "settleCommand",
+		Usage:       "settleUsage",
+		MaxArgs:     1,
+		Permission:  PermitOwners,
+		Handler:     settleCommand,
+	})
+	cr.Register(&Command{
+		Name:        "who",
+		Aliases:     []string{"кто"},
+		Description: "whoCommand",
+		Usage:       "periodUsage",
+		MaxArgs:     2,
+		Permission:  PermitOwners,
+		Handler:     whoCommand,
+	})
+	cr.Register(&Command{
+		Name:        "goal",
+		Aliases:     []string{"цель"},
+		Description: "goalCommand",
+		Usage:       "goalUsage",
+		MaxArgs:     goalMaxArgs,
+		Permission:  PermitOwners,
+		Handler:     goalCommand,
+	})
+	return cr
+}
+
+// Register adds the command, its name and aliases must not be taken
+func (cr *CommandRouter) Register(command *Command) {
+	for _, name := range append([]string{command.Name}, command.Aliases...) {
+		if _, ok := cr.byName[name]; ok {
+			panic("command /" + name + " is registered twice")
+		}
+		cr.byName[name] = command
+	}
+	cr.commands = append(cr.commands, command)
+}
+
+// Route runs the command of the message, ok is false when the message is not a command.
+// The commands of other bots, e.g. "/db@otherbot" in a group, get an empty reply.
+func (cr *CommandRouter) Route(tm *TableManagement, message *tgbotapi.Message) (reply string, ok bool) {
+	request, ok := cr.parse(message)
+	if !ok || request == nil {
+		return "", ok
+	}
+	request.Localizer = tm.localizer(request.ChatID, request.User)
+	command, found := cr.byName[request.Name]
+	if !found {
+		return request.Localizer.Text("unknownCommand", request.Name), true
+	}
+	if !tm.permits(command.Permission, request.User) {
+		log.Printf("Command /%s is not allowed to the user %d", command.Name, userID(request.User))
+		return request.Localizer.Text("forbidden"), true
+	}
+	if len(request.Args) < command.MinArgs || len(request.Args) > command.MaxArgs {
+		return request.Localizer.Text("usage", cr.usage(request.Localizer, command)), true
+	}
+	reply, err := command.Handler(tm, request)
+	if err != nil {
+		return errorText(request.Localizer, err), true
+	}
+	return reply, true
+}
+
+// Help lists the commands the user of the request may run
+func (cr *CommandRouter) Help(tm *TableManagement, request *CommandRequest) string {
+	var lines []string
+	for _, command := range cr.commands {
+		if !tm.permits(command.Permission, request.User) {
+			continue
+		}
+		names := []string{cr.usage(request.Localizer, command)}
+		for _, alias := range command.Aliases {
+			names = append(names, "/"+alias)
+		}
+		lines = append(lines, strings.Join(names, ", ")+" — "+request.Localizer.Text(command.Description))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (cr *CommandRouter) usage(l *Localizer, command *Command) string {
+	if command.Usage == "" {
+		return "/" + command.Name
+	}
+	return "/" + command.Name + " " + l.Text(command.Usage)
+}
+
+// parse splits "/history@bot март" into the command name and the arguments, the request is nil for other bots.
+// The message entities are not used, they do not mark Cyrillic commands like /остаток.
+func (cr *CommandRouter) parse(message *tgbotapi.Message) (*CommandRequest, bool) {
+	fields := strings.Fields(message.Text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") || len(fields[0]) == 1 {
+		return nil, false
+	}
+	name := strings.TrimPrefix(fields[0], "/")
+	if i := strings.Index(name, "@"); i != -1 {
+		if cr.BotName != "" && !strings.EqualFold(name[i+1:], cr.BotName) {
+			return nil, true
+		}
+		name = name[:i]
+	}
+	request := &CommandRequest{Name: strings.ToLower(name), Args: fields[1:], User: message.From}
+	if message.Chat != nil {
+		request.ChatID = message.Chat.ID
+	}
+	return request, true
+}
+
+// localizedBalance returns the balance with its title, e.g. "Остаток на день 500 рублей"
+func (tm *TableManagement) localizedBalance(l *Localizer, command string, message string) (string, error) {
+	balance, err := tm.GetTableBalance(command)
+	if err != nil {
+		return "", err
+	}
+	return l.Text(message, l.Roubles(balance)), nil
+}
+
+func (tm *TableManagement) permits(permission Permission, user *tgbotapi.User) bool {
+	switch permission {
+	case PermitOwners:
+		return len(tm.owners) == 0 || (user != nil && tm.owners[user.ID])
+	default:
+		return true
+	}
+}
+
+// parsePeriod reads the optional month and year arguments, e.g. "март", "2025" or "март 2025",
+// the current month and year are used for the missing ones. The invalid argument is returned if any.
+func (tm *TableManagement) parsePeriod(args []string) (year int, month string, invalid string) {
+	month, _ = tm.currentDate()
+	year = tm.now().Year()
+	for _, arg := range args {
+		if number, err := strconv.Atoi(arg); err == nil && number > 1900 {
+			year = number
+			continue
+		}
+		name, ok := parseMonth(arg)
+		if !ok {
+			return 0, "", arg
+		}
+		month = name
+	}
+	return year, month, ""
+}
+
+// parseMonth returns the sheet name of the month written as a name or a number, e.g. "март" or "3"
+func parseMonth(word string) (string, bool) {
+	for month := 1; month <= 12; month++ {
+		name := sheetName(time.Month(month))
+		if strings.EqualFold(word, name) || word == fmt.Sprint(month) {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Configuration holds all settings of the bot.
+// Values are applied in the following order, each next source overrides the previous one:
+// defaults, JSON config file, environment variables, command-line flags.
+type Configuration struct {
+	TelegramToken   string        `json:"telegram_token" env:"TELEGRAM_TOKEN" desc:"Telegram bot token"`
+	TelegramAPIURL  string        `json:"telegram_api_url" env:"TELEGRAM_API_URL" desc:"Bot API server URL, e.g. of a local server"`
+	Debug           bool          `json:"debug" env:"ENABLE_DEBUG" desc:"enable Telegram API debug output"`
+	Environment     string        `json:"environment" env:"ENVIRONMENT" desc:"set to heroku to receive updates via webhook"`
+	URL             string        `json:"url" env:"URL" desc:"public URL of the webhook"`
+	Port            string        `json:"port" env:"PORT" desc:"port of the http server"`
+	BindAddress     string        `json:"bind_address" env:"BIND_ADDRESS" desc:"address of the http server"`
+	ShutdownTimeout time.Duration `json:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" default:"10s" desc:"time to drain in-flight updates on shutdown"`
+	UpdateStoreFile string        `json:"update_store_file" env:"UPDATE_STORE_FILE" default:"updates.json" desc:"file to keep processed update IDs in"`
+	RecordFile      string        `json:"record_file" env:"RECORD_FILE" desc:"file to record updates and Sheets responses to for replays"`
+	UpdateWindow    int           `json:"update_window" env:"UPDATE_WINDOW" default:"1000" desc:"number of processed update and message IDs remembered"`
+
+	WebhookCert       string `json:"webhook_cert" env:"WEBHOOK_CERT" desc:"TLS certificate file of the webhook"`
+	WebhookKey        string `json:"webhook_key" env:"WEBHOOK_KEY" desc:"TLS key file of the webhook"`
+	WebhookSelfSigned bool   `json:"webhook_self_signed" env:"WEBHOOK_SELF_SIGNED" desc:"upload the webhook certificate to Telegram"`
+	WebhookCheckIP    bool   `json:"webhook_check_ip" env:"WEBHOOK_CHECK_IP" desc:"accept webhook requests from Telegram networks only"`
+	WebhookTrustProxy bool   `json:"webhook_trust_proxy" env:"WEBHOOK_TRUST_PROXY" desc:"take the source address from X-Forwarded-For"`
+
+	SheetID            string `json:"sheet_id" env:"SHEET_ID" desc:"Google spreadsheet ID"`
+	SheetYear          int    `json:"sheet_year" env:"SHEET_YEAR" desc:"year of the SHEET_ID spreadsheet, the current one when empty"`
+	SheetIDs           string `json:"sheet_ids" env:"SHEET_IDS" desc:"spreadsheet IDs of the years, e.g. 2025=id1,2026=id2"`
+	SheetsEndpoint     string `json:"sheets_endpoint" env:"SHEETS_ENDPOINT" desc:"Sheets API endpoint, e.g. of a local backend"`
+	GoogleClientID     string `json:"google_client_id" env:"GOOGLE_CLIENT_ID" desc:"OAuth client ID"`
+	GoogleProjectID    string `json:"google_project_id" env:"GOOGLE_PROJECT_ID" desc:"OAuth project ID"`
+	GoogleAuthURI      string `json:"google_auth_uri" env:"GOOGLE_AUTH_URI" desc:"OAuth auth URI"`
+	GoogleTokenURI     string `json:"google_token_uri" env:"GOOGLE_TOKEN_URI" desc:"OAuth token URI"`
+	GoogleClientSecret string `json:"google_client_secret" env:"GOOGLE_CLIENT_SECRET" desc:"OAuth client secret"`
+	GoogleRedirectURIs string `json:"google_redirect_uris" env:"GOOGLE_REDIRECT_URIS" desc:"OAuth redirect URIs"`
+	SheetAccessToken   string `json:"sheet_access_token" env:"SHEET_ACCESS_TOKEN" desc:"OAuth access token"`
+	SheetTokenType     string `json:"sheet_token_type" env:"SHEET_TOKEN_TYPE" desc:"OAuth token type"`
+	SheetRefreshToken  string `json:"sheet_refresh_token" env:"SHEET_REFRESH_TOKEN" desc:"OAuth refresh token"`
+	SheetTokenExpiry   string `json:"sheet_token_expire_time" env:"SHEET_TOKEN_EXPIRE_TIME" desc:"OAuth token expiry in RFC3339"`
+	TokenFile          string `json:"token_file" env:"TOKEN_FILE" default:"token.json" desc:"file to keep refreshed OAuth tokens in"`
+	AuthAddress        string `json:"auth_address" env:"AUTH_ADDRESS" default:"127.0.0.1:0" desc:"loopback address the auth command listens on"`
+	ServiceAccountFile string `json:"google_service_account_file" env:"GOOGLE_SERVICE_ACCOUNT_FILE" desc:"service account JSON key file"`
+	ServiceAccountKey  string `json:"google_service_account" env:"GOOGLE_SERVICE_ACCOUNT" desc:"base64 encoded service account JSON key"`
+
+	CurrencyRatesFile string `json:"currency_rates_file" env:"CURRENCY_RATES_FILE" desc:"currency rates in the Central Bank XML daily format"`
+
+	ConfirmThreshold float64       `json:"confirm_threshold" env:"CONFIRM_THRESHOLD" default:"0" desc:"amounts from this one on are written after a confirmation, 0 turns it off"`
+	ConfirmTimeout   time.Duration `json:"confirm_timeout" env:"CONFIRM_TIMEOUT" default:"10m" desc:"time to confirm a large amount"`
+
+	QuickAddFile    string        `json:"quick_add_file" env:"QUICK_ADD_FILE" default:"quick_add.json" desc:"file to keep the frequent expenses of every chat in"`
+	QuickAddButtons int           `json:"quick_add_buttons" env:"QUICK_ADD_BUTTONS" default:"6" desc:"number of frequent expenses on the keyboard, 0 turns it off"`
+	QuickAddRefresh time.Duration `json:"quick_add_refresh" env:"QUICK_ADD_REFRESH" default:"168h" desc:"how often the keyboard of frequent expenses is rebuilt"`
+
+	InlineUsers string `json:"inline_users" env:"INLINE_USERS" desc:"comma separated Telegram user IDs allowed to use the inline mode"`
+	OwnerUsers  string `json:"owner_users" env:"OWNER_USERS" desc:"comma separated Telegram user IDs allowed to see the balances, everyone when empty"`
+
+	Language         string `json:"language" env:"DEFAULT_LANGUAGE" default:"ru" desc:"language of the replies when neither the chat nor the user has one, ru or en"`
+	ChatSettingsFile string `json:"chat_settings_file" env:"CHAT_SETTINGS_FILE" default:"chats.json" desc:"file to keep the settings of every chat in"`
+
+	Timezone  string `json:"timezone" env:"TIMEZONE" desc:"IANA timezone of the dates when the chat has none, e.g. Europe/Moscow, the server one when empty"`
+	DayEndsAt int    `json:"day_ends_at" env:"DAY_ENDS_AT" default:"0" desc:"hour from 0 to 12 until which purchases belong to the previous day"`
+
+	RecurringFile     string        `json:"recurring_file" env:"RECURRING_FILE" default:"recurring.json" desc:"file to keep the recurring expenses of every chat in"`
+	RecurringInterval time.Duration `json:"recurring_interval" env:"RECURRING_INTERVAL" default:"1h" desc:"how often the due recurring expenses are written and the goals are checked, 0 turns it off"`
+
+	LedgerFile string `json:"ledger_file" env:"LEDGER_FILE" default:"ledger.json" desc:"file to keep who owes whom in every chat"`
+
+	AuthorMode       string `json:"author_mode" env:"AUTHOR_MODE" desc:"put the short name of the author before (prefix) or after (suffix) the description, off when empty"`
+	AuthorNames      string `json:"author_names" env:"AUTHOR_NAMES" desc:"comma separated Telegram user IDs with their short names, e.g. 123=Аня,456=Петя"`
+	AuthorTotalsFile string `json:"author_totals_file" env:"AUTHOR_TOTALS_FILE" default:"authors.json" desc:"file to keep the monthly totals of every author in"`
+
+	GoalsFile string `json:"goals_file" env:"GOALS_FILE" default:"goals.json" desc:"file to keep the savings goals of every chat in"`
+
+	args []string
+}
+
+// ConfigurationError lists every problem found in the configuration
+type ConfigurationError struct {
+	Problems []string
+}
+
+func (ce *ConfigurationError) Error() string {
+	return "Configuration is invalid:\n  - " + strings.Join(ce.Problems, "\n  - ")
+}
+
+// LoadConfiguration reads the configuration from the file, environment and command-line arguments
+func LoadConfiguration(args []string) (*Configuration, error) {
+	config := &Configuration{}
+	if err := config.applyDefaults(); err != nil {
+		return nil, err
+	}
+	flags := flag.NewFlagSet("tinkoff-table-bot", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "JSON configuration file")
+	values := config.defineFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if *configFile != "" {
+		if err := config.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+	if err := config.loadEnvironment(); err != nil {
+		return nil, err
+	}
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		if field, ok := values[f.Name]; ok && err == nil {
+			err = setField(field, f.Value.String(), "flag -"+f.Name)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	config.args = flags.Args()
+	return config, nil
+}
+
+// Args returns command-line arguments left after the flags
+func (c *Configuration) Args() []string {
+	return c.args
+}
+
+// Validate checks that required settings are present and consistent
+func (c *Configuration) Validate() error {
+	return c.validate(true)
+}
+
+// ValidateSheet checks only the settings needed to work with the spreadsheet
+func (c *Configuration) ValidateSheet() error {
+	return c.validate(false)
+}
+
+func (c *Configuration) validate(telegram bool) error {
+	var problems []string
+	require := func(value string, env string) {
+		if value == "" {
+			problems = append(problems, fmt.Sprintf("%s (-%s) is required", env, flagName(env)))
+		}
+	}
+	if c.SheetID == "" && c.SheetIDs == "" {
+		problems = append(problems, "SHEET_ID (-sheet-id) or SHEET_IDS (-sheet-ids) is required")
+	}
+	if _, err := c.Spreadsheets(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if telegram {
+		require(c.TelegramToken, "TELEGRAM_TOKEN")
+	}
+	if telegram && c.IsWebhook() {
+		require(c.URL, "URL")
+		require(c.Port, "PORT")
+	}
+	if c.UpdateWindow <= 0 {
+		problems = append(problems, "UPDATE_WINDOW must be positive")
+	}
+	if c.ConfirmThreshold < 0 {
+		problems = append(problems, "CONFIRM_THRESHOLD must not be negative")
+	}
+	if c.ConfirmThreshold > 0 && c.ConfirmTimeout <= 0 {
+		problems = append(problems, "CONFIRM_TIMEOUT must be positive")
+	}
+	if _, err := parseUserIDs(c.InlineUsers); err != nil {
+		problems = append(problems, fmt.Sprintf("INLINE_USERS must be comma separated user IDs: %v", err))
+	}
+	if _, err := parseUserIDs(c.OwnerUsers); err != nil {
+		problems = append(problems, fmt.Sprintf("OWNER_USERS must be comma separated user IDs: %v", err))
+	}
+	if _, ok := messages[c.Language]; !ok {
+		problems = append(problems, "DEFAULT_LANGUAGE must be ru or en")
+	}
+	if _, err := loadLocation(c.Timezone); err != nil {
+		problems = append(problems, fmt.Sprintf("TIMEZONE is not an IANA timezone: %v", err))
+	}
+	if !validDayEnd(c.DayEndsAt) {
+		problems = append(problems, "DAY_ENDS_AT must be from 0 to 12")
+	}
+	if c.RecurringInterval < 0 {
+		problems = append(problems, "RECURRING_INTERVAL must not be negative")
+	}
+	if c.AuthorMode != "" && c.AuthorMode != authorPrefix && c.AuthorMode != authorSuffix {
+		problems = append(problems, "AUTHOR_MODE must be prefix or suffix")
+	}
+	if _, err := parseAuthorNames(c.AuthorNames); err != nil {
+		problems = append(problems, fmt.Sprintf("AUTHOR_NAMES must be comma separated id=name pairs: %v", err))
+	}
+	if c.QuickAddButtons < 0 {
+		problems = append(problems, "QUICK_ADD_BUTTONS must not be negative")
+	}
+	if (c.WebhookCert == "") != (c.WebhookKey == "") {
+		problems = append(problems, "WEBHOOK_CERT and WEBHOOK_KEY must be set together")
+	}
+	if c.SheetTokenExpiry != "" {
+		if _, err := time.Parse(time.RFC3339, c.SheetTokenExpiry); err != nil {
+			problems = append(problems, fmt.Sprintf("SHEET_TOKEN_EXPIRE_TIME is not RFC3339: %v", err))
+		}
+	}
+	switch {
+	case c.ServiceAccountFile != "" && c.ServiceAccountKey != "":
+		problems = append(problems, "GOOGLE_SERVICE_ACCOUNT_FILE and GOOGLE_SERVICE_ACCOUNT are mutually exclusive")
+	case c.ServiceAccountFile != "":
+		if _, err := os.Stat(c.ServiceAccountFile); err != nil {
+			problems = append(problems, fmt.Sprintf("GOOGLE_SERVICE_ACCOUNT_FILE is not readable: %v", err))
+		}
+	case c.ServiceAccountKey != "":
+		if _, err := base64.StdEncoding.DecodeString(c.ServiceAccountKey); err != nil {
+			problems = append(problems, fmt.Sprintf("GOOGLE_SERVICE_ACCOUNT is not base64: %v", err))
+		}
+	case !c.hasClientCredentials() && c.SheetsEndpoint == "":
+		if _, err := os.Stat("credentials.json"); err != nil {
+			problems = append(problems, "Google credentials are missing: set GOOGLE_SERVICE_ACCOUNT_FILE, GOOGLE_SERVICE_ACCOUNT "+
+				"or GOOGLE_CLIENT_ID, GOOGLE_PROJECT_ID, GOOGLE_AUTH_URI, GOOGLE_TOKEN_URI, GOOGLE_CLIENT_SECRET "+
+				"and GOOGLE_REDIRECT_URIS or provide credentials.json")
+		}
+	}
+	if len(problems) > 0 {
+		return &ConfigurationError{Problems: problems}
+	}
+	return nil
+}
+
+// Secrets returns the values which must never get to logs or recordings
+func (c *Configuration) Secrets() []string {
+	return []string{c.TelegramToken, c.GoogleClientID, c.GoogleClientSecret, c.SheetAccessToken,
+		c.SheetRefreshToken, c.ServiceAccountKey}
+}
+
+// Spreadsheets returns the spreadsheet ID of every year, SHEET_ID is the one of SHEET_YEAR
+func (c *Configuration) Spreadsheets() (map[int]string, error) {
+	spreadsheets := make(map[int]string)
+	for _, pair := range strings.Split(c.SheetIDs, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		year, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if len(parts) != 2 || err != nil || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("SHEET_IDS must be year=id pairs, got %q", pair)
+		}
+		spreadsheets[year] = strings.TrimSpace(parts[1])
+	}
+	if c.SheetID != "" {
+		year := c.SheetYear
+		if year == 0 {
+			year = c.Now().Year()
+		}
+		if id, ok := spreadsheets[year]; ok && id != c.SheetID {
+			return nil, fmt.Errorf("SHEET_ID and SHEET_IDS have different spreadsheets of %d", year)
+		}
+		spreadsheets[year] = c.SheetID
+	}
+	return spreadsheets, nil
+}
+
+// Now returns the time in TIMEZONE moved back by DAY_ENDS_AT, the clock of a chat without its own settings
+func (c *Configuration) Now() time.Time {
+	location, err := loadLocation(c.Timezone)
+	if err != nil {
+		location = time.Local
+	}
+	return time.Now().In(location).Add(-time.Duration(c.DayEndsAt) * time.Hour)
+}
+
+// IsWebhook reports whether updates are received via webhook instead of polling
+func (c *Configuration) IsWebhook() bool {
+	return c.Environment == "heroku"
+}
+
+// ConnectionProperties returns properties needed to connect to the spreadsheet
+func (c *Configuration) ConnectionProperties() *ConnectionProperties {
+	return &ConnectionProperties{
+		SpreadsheetID: c.SheetID,
+		ClientID:      c.GoogleClientID,
+		ProjectID:     c.GoogleProjectID,
+		AuthURI:       c.GoogleAuthURI,
+		TokenURI:      c.GoogleTokenURI,
+		ClientSecret:  c.GoogleClientSecret,
+		RedirectUris:  c.GoogleRedirectURIs,
+		AccessToken:   c.SheetAccessToken,
+		TokenType:     c.SheetTokenType,
+		RefreshToken:  c.SheetRefreshToken,
+		ExpireTime:    c.SheetTokenExpiry,
+
+		ServiceAccountFile: c.ServiceAccountFile,
+		ServiceAccountKey:  c.ServiceAccountKey,
+		Endpoint:           c.SheetsEndpoint,
+	}
+}
+
+// WebhookProperties returns properties needed to receive updates via webhook
+func (c *Configuration) WebhookProperties() *WebhookProperties {
+	return &WebhookProperties{
+		PublicURL:   c.URL,
+		BindAddress: c.BindAddress,
+		Port:        c.Port,
+		CertFile:    c.WebhookCert,
+		KeyFile:     c.WebhookKey,
+		SelfSigned:  c.WebhookSelfSigned,
+		CheckIP:     c.WebhookCheckIP,
+		TrustProxy:  c.WebhookTrustProxy,
+	}
+}
+
+func (c *Configuration) hasClientCredentials() bool {
+	return c.GoogleClientID != "" && c.GoogleProjectID != "" && c.GoogleAuthURI != "" &&
+		c.GoogleTokenURI != "" && c.GoogleClientSecret != "" && c.GoogleRedirectURIs != ""
+}
+
+func (c *Configuration) applyDefaults() error {
+	return c.eachField(func(field reflect.Value, tag reflect.StructTag) error {
+		if value, ok := tag.Lookup("default"); ok {
+			return setField(field, value, "default of "+tag.Get("env"))
+		}
+		return nil
+	})
+}
+
+func (c *Configuration) loadFile(path string) error {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %v", err)
+	}
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(bytes, &raw); err != nil {
+		return fmt.Errorf("could not parse config file %s: %v", path, err)
+	}
+	return c.eachField(func(field reflect.Value, tag reflect.StructTag) error {
+		value, ok := raw[tag.Get("json")]
+		if !ok {
+			return nil
+		}
+		var text string
+		if err := json.Unmarshal(value, &text); err != nil {
+			// Numbers and booleans are used as they are written in the file
+			text = string(value)
+		}
+		return setField(field, text, "config file key "+tag.Get("json"))
+	})
+}
+
+func (c *Configuration) loadEnvironment() error {
+	return c.eachField(func(field reflect.Value, tag reflect.StructTag) error {
+		if value, ok := os.LookupEnv(tag.Get("env")); ok && value != "" {
+			return setField(field, value, "environment variable "+tag.Get("env"))
+		}
+		return nil
+	})
+}
+
+func (c *Configuration) defineFlags(flags *flag.FlagSet) map[string]reflect.Value {
+	values := make(map[string]reflect.Value)
+	c.eachField(func(field reflect.Value, tag reflect.StructTag) error {
+		name := flagName(tag.Get("env"))
+		flags.String(name, "", tag.Get("desc"))
+		values[name] = field
+		return nil
+	})
+	return values
+}
+
+func (c *Configuration) eachField(apply func(field reflect.Value, tag reflect.StructTag) error) error {
+	value := reflect.ValueOf(c).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		tag := value.Type().Field(i).Tag
+		if tag.Get("env") == "" {
+			continue
+		}
+		if err := apply(value.Field(i), tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func flagName(env string) string {
+	return strings.Replace(strings.ToLower(env), "_", "-", -1)
+}
+
+func setField(field reflect.Value, value string, source string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+	case bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a boolean", source, value)
+		}
+		field.SetBool(parsed)
+	case time.Duration:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a duration", source, value)
+		}
+		field.SetInt(int64(parsed))
+	case int, int64:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not an integer", source, value)
+		}
+		field.SetInt(parsed)
+	case float64:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", source, value)
+		}
+		field.SetFloat(parsed)
+	default:
+		return fmt.Errorf("%s: unsupported setting type %s", source, field.Type())
+	}
+	return nil
+}
@@ -16,4 +16,41 @@ heroku config:set -a ${herokuProjectName} SHEET_REFRESH_TOKEN=<SHEET_REFRESH_TOK
 heroku config:set -a ${herokuProjectName} SHEET_TOKEN_EXPIRE_TIME=<SHEET_TOKEN_EXPIRE_TIME>
 heroku config:set -a ${herokuProjectName} ENABLE_DEBUG=<ENABLE_DEBUG>
 heroku config:set -a ${herokuProjectName} ENVIRONMENT=<ENVIRONMENT>
-heroku config:set -a ${herokuProjectName} URL=<URL>
\ No newline at end of file
+heroku config:set -a ${herokuProjectName} URL=<URL>
+heroku config:set -a ${herokuProjectName} SHUTDOWN_TIMEOUT=<SHUTDOWN_TIMEOUT>
+heroku config:set -a ${herokuProjectName} BIND_ADDRESS=<BIND_ADDRESS>
+heroku config:set -a ${herokuProjectName} WEBHOOK_CERT=<WEBHOOK_CERT>
+heroku config:set -a ${herokuProjectName} WEBHOOK_KEY=<WEBHOOK_KEY>
+heroku config:set -a ${herokuProjectName} WEBHOOK_SELF_SIGNED=<WEBHOOK_SELF_SIGNED>
+heroku config:set -a ${herokuProjectName} WEBHOOK_CHECK_IP=<WEBHOOK_CHECK_IP>
+heroku config:set -a ${herokuProjectName} WEBHOOK_TRUST_PROXY=<WEBHOOK_TRUST_PROXY>
+heroku config:set -a ${herokuProjectName} CONFIG_FILE=<CONFIG_FILE>
+heroku config:set -a ${herokuProjectName} TOKEN_FILE=<TOKEN_FILE>
+heroku config:set -a ${herokuProjectName} GOOGLE_SERVICE_ACCOUNT_FILE=<GOOGLE_SERVICE_ACCOUNT_FILE>
+heroku config:set -a ${herokuProjectName} GOOGLE_SERVICE_ACCOUNT=<GOOGLE_SERVICE_ACCOUNT>
+heroku config:set -a ${herokuProjectName} UPDATE_STORE_FILE=<UPDATE_STORE_FILE>
+heroku config:set -a ${herokuProjectName} UPDATE_WINDOW=<UPDATE_WINDOW>
+heroku config:set -a ${herokuProjectName} SHEETS_ENDPOINT=<SHEETS_ENDPOINT>
+heroku config:set -a ${herokuProjectName} TELEGRAM_API_URL=<TELEGRAM_API_URL>
+heroku config:set -a ${herokuProjectName} RECORD_FILE=<RECORD_FILE>
+heroku config:set -a ${herokuProjectName} CURRENCY_RATES_FILE=<CURRENCY_RATES_FILE>
+heroku config:set -a ${herokuProjectName} CONFIRM_THRESHOLD=<CONFIRM_THRESHOLD>
+heroku config:set -a ${herokuProjectName} CONFIRM_TIMEOUT=<CONFIRM_TIMEOUT>
+heroku config:set -a ${herokuProjectName} QUICK_ADD_FILE=<QUICK_ADD_FILE>
+heroku config:set -a ${herokuProjectName} QUICK_ADD_BUTTONS=<QUICK_ADD_BUTTONS>
+heroku config:set -a ${herokuProjectName} QUICK_ADD_REFRESH=<QUICK_ADD_REFRESH>
+heroku config:set -a ${herokuProjectName} INLINE_USERS=<INLINE_USERS>
+heroku config:set -a ${herokuProjectName} OWNER_USERS=<OWNER_USERS>
+heroku config:set -a ${herokuProjectName} DEFAULT_LANGUAGE=<DEFAULT_LANGUAGE>
+heroku config:set -a ${herokuProjectName} CHAT_SETTINGS_FILE=<CHAT_SETTINGS_FILE>
+heroku config:set -a ${herokuProjectName} SHEET_YEAR=<SHEET_YEAR>
+heroku config:set -a ${herokuProjectName} SHEET_IDS=<SHEET_IDS>
+heroku config:set -a ${herokuProjectName} TIMEZONE=<TIMEZONE>
+heroku config:set -a ${herokuProjectName} DAY_ENDS_AT=<DAY_ENDS_AT>
+heroku config:set -a ${herokuProjectName} RECURRING_FILE=<RECURRING_FILE>
+heroku config:set -a ${herokuProjectName} RECURRING_INTERVAL=<RECURRING_INTERVAL>
+heroku config:set -a ${herokuProjectName} LEDGER_FILE=<LEDGER_FILE>
+heroku config:set -a ${herokuProjectName} AUTHOR_MODE=<AUTHOR_MODE>
+heroku config:set -a ${herokuProjectName} AUTHOR_NAMES=<AUTHOR_NAMES>
+heroku config:set -a ${herokuProjectName} AUTHOR_TOTALS_FILE=<AUTHOR_TOTALS_FILE>
+heroku config:set -a ${herokuProjectName} GOALS_FILE=<GOALS_FILE>
\ No newline at end of file
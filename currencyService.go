@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// roubles is the currency the sheet is kept in
+const roubles = "RUB"
+
+// currencyAliases maps currency symbols and words to ISO codes
+var currencyAliases = map[string]string{
+	"₽": roubles, "р": roubles, "руб": roubles, "рубль": roubles, "рубля": roubles, "рублей": roubles, "rub": roubles,
+	"$": "USD", "usd": "USD", "доллар": "USD", "доллара": "USD", "долларов": "USD", "бакс": "USD", "баксов": "USD",
+	"€": "EUR", "eur": "EUR", "евро": "EUR",
+	"£": "GBP", "gbp": "GBP", "фунт": "GBP", "фунта": "GBP", "фунтов": "GBP",
+	"¥": "CNY", "cny": "CNY", "юань": "CNY", "юаня": "CNY", "юаней": "CNY",
+	"₸": "KZT", "kzt": "KZT", "тенге": "KZT",
+	"₺": "TRY", "try": "TRY", "лира": "TRY", "лиры": "TRY", "лир": "TRY",
+	"₾": "GEL", "gel": "GEL", "лари": "GEL",
+	"֏": "AMD", "amd": "AMD", "драм": "AMD", "драма": "AMD", "драмов": "AMD",
+	"byn": "BYN",
+}
+
+// currencySymbols are aliases which may be written next to the number without a space, e.g. $25 or 25€
+var currencySymbols = []string{"₽", "$", "€", "£", "¥", "₸", "₺", "₾", "֏"}
+
+// CurrencyRates converts amounts in foreign currencies to roubles
+type CurrencyRates struct {
+	Date  string
+	rates map[string]float64
+}
+
+// cbrRates is the daily rates document of the Central Bank of Russia (XML_daily.asp)
+type cbrRates struct {
+	Date    string `xml:"Date,attr"`
+	Valutes []struct {
+		CharCode string `xml:"CharCode"`
+		Nominal  string `xml:"Nominal"`
+		Value    string `xml:"Value"`
+	} `xml:"Valute"`
+}
+
+// NewCurrencyRates creates CurrencyRates instant with roubles only
+func NewCurrencyRates() *CurrencyRates {
+	return &CurrencyRates{rates: map[string]float64{roubles: 1}}
+}
+
+// LoadCurrencyRates reads rates from a file in the Central Bank XML daily format
+func LoadCurrencyRates(path string) (*CurrencyRates, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCurrencyRates(content)
+}
+
+// ParseCurrencyRates parses rates in the Central Bank XML daily format
+func ParseCurrencyRates(content []byte) (*CurrencyRates, error) {
+	var document cbrRates
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	decoder.CharsetReader = charsetReader
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("could not parse currency rates: %v", err)
+	}
+	cr := NewCurrencyRates()
+	cr.Date = document.Date
+	for _, valute := range document.Valutes {
+		value, err := strconv.ParseFloat(strings.Replace(valute.Value, ",", ".", 1), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate of %s: %q", valute.CharCode, valute.Value)
+		}
+		nominal, err := strconv.ParseFloat(valute.Nominal, 64)
+		if err != nil || nominal <= 0 {
+			return nil, fmt.Errorf("invalid nominal of %s: %q", valute.CharCode, valute.Nominal)
+		}
+		cr.rates[strings.ToUpper(valute.CharCode)] = value / nominal
+	}
+	return cr, nil
+}
+
+// RateError is returned for a currency without a rate, e.g. when no rates are loaded
+type RateError struct {
+	Currency string
+}
+
+func (re *RateError) Error() string {
+	return fmt.Sprintf("no rate for %s", re.Currency)
+}
+
+// Convert returns the amount in roubles
+func (cr *CurrencyRates) Convert(amount float64, currency string) (float64, error) {
+	rate, ok := cr.rates[currency]
+	if !ok {
+		return 0, &RateError{Currency: currency}
+	}
+	return amount * rate, nil
+}
+
+// lookupCurrency returns the ISO code of the currency alias
+func lookupCurrency(word string) (string, bool) {
+	code, ok := currencyAliases[strings.ToLower(strings.TrimSuffix(word, "."))]
+	return code, ok
+}
+
+// isCurrencyCode reports whether the word is a currency symbol or an ISO code, e.g. "$" or "usd".
+// Only these may stand before the amount, the words like "фунт" or "драма" are ordinary words there.
+func isCurrencyCode(word string) bool {
+	code, ok := lookupCurrency(word)
+	if !ok {
+		return false
+	}
+	for _, symbol := range currencySymbols {
+		if word == symbol {
+			return true
+		}
+	}
+	return strings.EqualFold(strings.TrimSuffix(word, "."), code)
+}
+
+// splitCurrencySymbol separates a currency symbol written next to the number, e.g. $25 or 25€
+func splitCurrencySymbol(word string) (rest string, currency string) {
+	for _, symbol := range currencySymbols {
+		if strings.HasPrefix(word, symbol) && len(word) > len(symbol) {
+			return word[len(symbol):], currencyAliases[symbol]
+		}
+		if strings.HasSuffix(word, symbol) && len(word) > len(symbol) {
+			return word[:len(word)-len(symbol)], currencyAliases[symbol]
+		}
+	}
+	return word, ""
+}
+
+// charsetReader decodes windows-1251 which the Central Bank uses for its documents
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8":
+		return input, nil
+	case "windows-1251", "cp1251":
+		content, err := ioutil.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		var decoded strings.Builder
+		for _, b := range content {
+			if b < 0x80 {
+				decoded.WriteByte(b)
+			} else {
+				decoded.WriteRune(windows1251[b-0x80])
+			}
+		}
+		return strings.NewReader(decoded.String()), nil
+	default:
+		return nil, fmt.Errorf("unsupported charset %s", charset)
+	}
+}
+
+// windows1251 maps the upper half of windows-1251 to unicode
+var windows1251 = [128]rune{
+	'Ђ', 'Ѓ', '‚', 'ѓ', '„', '…', '†', '‡', '€', '‰', 'Љ', '‹', 'Њ', 'Ќ', 'Ћ', 'Џ',
+	'ђ', '‘', '’', '“', '”', '•', '–', '—', '\ufffd', '™', 'љ', '›', 'њ', 'ќ', 'ћ', 'џ',
+	'\u00a0', 'Ў', 'ў', 'Ј', '¤', 'Ґ', '¦', '§', 'Ё', '©', 'Є', '«', '¬', '\u00ad', '®', 'Ї',
+	'°', '±', 'І', 'і', 'ґ', 'µ', '¶', '·', 'ё', '№', 'є', '»', 'ј', 'Ѕ', 'ѕ', 'ї',
+	'А', 'Б', 'В', 'Г', 'Д', 'Е', 'Ж', 'З', 'И', 'Й', 'К', 'Л', 'М', 'Н', 'О', 'П',
+	'Р', 'С', 'Т', 'У', 'Ф', 'Х', 'Ц', 'Ч', 'Ш', 'Щ', 'Ъ', 'Ы', 'Ь', 'Э', 'Ю', 'Я',
+	'а', 'б', 'в', 'г', 'д', 'е', 'ж', 'з', 'и', 'й', 'к', 'л', 'м', 'н', 'о', 'п',
+	'р', 'с', 'т', 'у', 'ф', 'х', 'ц', 'ч', 'ш', 'щ', 'ъ', 'ы', 'ь', 'э', 'ю', 'я',
+}
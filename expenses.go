@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expense is a single entry of a message, a message may have one entry per line
+type Expense struct {
+	Date        time.Time
+	Description string
+	Sum         float64
+	// Text is the line the expense was parsed from without the date
+	Text string
+	// Author is the short name put into the description when the author mode is on
+	Author string
+	// Members are the ones mentioned in the line, they share the expense with its author
+	Members []string
+}
+
+// DateError is returned for a date at the beginning of a line which can not be used
+type DateError struct {
+	Word   string
+	Reason string
+}
+
+func (de *DateError) Error() string {
+	return fmt.Sprintf("invalid date %q: %s", de.Word, de.Reason)
+}
+
+// relativeDates are the words which may be used instead of a date
+var relativeDates = map[string]int{"сегодня": 0, "вчера": -1, "позавчера": -2}
+
+// dateLayouts are the date formats which may start a line, e.g. "12.03 кофе 150"
+var dateLayouts = []string{"2.1.2006", "2.1.06", "2.1", "2/1/2006", "2/1"}
+
+// parseExpenses parses every non-empty line of the input as a separate expense, the mentions of a line are its members
+func (tm *TableManagement) parseExpenses(input string) ([]*Expense, error) {
+	var expenses []*Expense
+	for _, line := range strings.Split(input, "\n") {
+		line, members := splitMentions(line)
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		date, rest, err := tm.splitDate(line)
+		if err != nil {
+			return nil, err
+		}
+		description, sum, err := tm.parseInput(rest)
+		if err != nil {
+			return nil, err
+		}
+		expenses = append(expenses, &Expense{Date: date, Description: description, Sum: sum, Text: rest, Members: members})
+	}
+	return expenses, nil
+}
+
+// splitDate separates an optional date written at the beginning of the line.
+// The first word is taken as a date only when the rest of the line has an amount,
+// so "12.03 кофе 150" is an expense of 12 March while "кофе 12.03" costs 12.03.
+func (tm *TableManagement) splitDate(line string) (date time.Time, rest string, err error) {
+	today := tm.today()
+	words := splitWords(line)
+	if len(words) < 2 || !tm.hasAmount(words[1:]) {
+		return today, line, nil
+	}
+	first := strings.ToLower(words[0])
+	rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), words[0]))
+	if offset, ok := relativeDates[first]; ok {
+		return today.AddDate(0, 0, offset), rest, nil
+	}
+	for _, layout := range dateLayouts {
+		parsed, parseErr := time.ParseInLocation(layout, first, today.Location())
+		if parseErr != nil {
+			continue
+		}
+		if !strings.Contains(layout, "06") {
+			parsed = parsed.AddDate(today.Year(), 0, 0)
+			// "31.12" written on the 1st of January is the last day of the previous year
+			if parsed.After(today) {
+				parsed = parsed.AddDate(-1, 0, 0)
+			}
+		}
+		if parsed.After(today) {
+			return today, "", &DateError{Word: words[0], Reason: "the date is in the future"}
+		}
+		if !tm.hasYear(parsed.Year()) {
+			return today, "", &DateError{Word: words[0], Reason: "there is no spreadsheet of the year"}
+		}
+		return parsed, rest, nil
+	}
+	return today, line, nil
+}
+
+func (tm *TableManagement) hasAmount(words []string) bool {
+	for _, word := range words {
+		if tm.isAmount(word) {
+			return true
+		}
+	}
+	return false
+}
+
+// today returns the beginning of the current day
+func (tm *TableManagement) today() time.Time {
+	year, month, day := tm.now().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, tm.now().Location())
+}
+
+// formatExpenses lists the expenses for the reply, the date is shown only for the past days
+func (tm *TableManagement) formatExpenses(expenses []*Expense) string {
+	today := tm.today()
+	var lines []string
+	for _, expense := range expenses {
+		line := strconv.FormatFloat(expense.Sum, 'f', -1, 64)
+		if expense.Description != "" {
+			line = expense.Description + " — " + line
+		}
+		if !expense.Date.Equal(today) {
+			line = expense.Date.Format("02.01") + " " + line
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
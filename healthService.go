@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// HealthService serves liveness and readiness probes
+type HealthService struct {
+	ready int32
+}
+
+// NewHealthService creates new HealthService instant which is not ready yet
+func NewHealthService() *HealthService {
+	return &HealthService{}
+}
+
+// Register adds /healthz and /readyz handlers to the mux
+func (hs *HealthService) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", hs.healthz)
+	mux.HandleFunc("/readyz", hs.readyz)
+}
+
+// SetReady marks the bot as able or unable to process updates
+func (hs *HealthService) SetReady(ready bool) {
+	var value int32
+	if ready {
+		value = 1
+	}
+	atomic.StoreInt32(&hs.ready, value)
+}
+
+// IsReady reports whether the bot processes updates
+func (hs *HealthService) IsReady() bool {
+	return atomic.LoadInt32(&hs.ready) == 1
+}
+
+func (hs *HealthService) healthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+func (hs *HealthService) readyz(w http.ResponseWriter, r *http.Request) {
+	if !hs.IsReady() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("not ready"))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ready"))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Inline result IDs, the chosen one is reported back when inline feedback is on
+const (
+	inlineAddResult   = "add"
+	inlineLargeResult = "large"
+)
+
+// inlineBalances are the balances offered for an empty inline query
+var inlineBalances = []struct {
+	command string
+	message string
+}{
+	{"db", "dailyBalance"},
+	{"mb", "monthlyBalance"},
+	{"ma", "accumulation"},
+}
+
+// processInlineQuery answers "@bot" with the balances and "@bot 300 кофе" with the expense to record.
+// The results are personal and never cached, so other users do not see them.
+func processInlineQuery(tm *TableManagement, query *tgbotapi.InlineQuery) tgbotapi.InlineConfig {
+	answer := tgbotapi.InlineConfig{InlineQueryID: query.ID, IsPersonal: true, CacheTime: 0, Results: []interface{}{}}
+	if !tm.isInlineUser(query.From) {
+		log.Printf("Inline query from the user %d who is not allowed", userID(query.From))
+		return answer
+	}
+	// The private chat with the user has the same ID as the user
+	tm = tm.forChat(int64(query.From.ID))
+	l := tm.localizer(int64(query.From.ID), query.From)
+	text := strings.TrimSpace(query.Query)
+	if text == "" {
+		for _, balance := range inlineBalances {
+			value, err := tm.GetTableBalance(balance.command)
+			if err != nil {
+				log.Printf("Following error accured: %v", err)
+				continue
+			}
+			title := l.Text(balance.message, l.Roubles(value))
+			article := tgbotapi.NewInlineQueryResultArticle(balance.command, title, title)
+			answer.Results = append(answer.Results, article)
+		}
+		return answer
+	}
+	expenses, err := tm.parseExpenses(text)
+	if err != nil || !hasSum(expenses) {
+		return answer
+	}
+	if tm.pending.NeedsConfirmation(expenses) {
+		article := tgbotapi.NewInlineQueryResultArticle(inlineLargeResult, l.Text("inlineConfirm"), l.Text("inlineConfirmText", text))
+		article.Description = tm.formatExpenses(expenses)
+		answer.Results = append(answer.Results, article)
+		return answer
+	}
+	article := tgbotapi.NewInlineQueryResultArticle(inlineAddResult, l.Text("inlineAdd"), l.Text("inlineAddText", tm.formatExpenses(expenses)))
+	article.Description = tm.formatExpenses(expenses)
+	answer.Results = append(answer.Results, article)
+	return answer
+}
+
+// processChosenInlineResult records the expense of the query once its result is sent to a chat
+func processChosenInlineResult(tm *TableManagement, result *tgbotapi.ChosenInlineResult) {
+	if result.ResultID != inlineAddResult {
+		return
+	}
+	if !tm.isInlineUser(result.From) {
+		log.Printf("Inline result chosen by the user %d who is not allowed", userID(result.From))
+		return
+	}
+	tm = tm.forChat(int64(result.From.ID))
+	replyText, _ := recordExpenses(tm, tm.localizer(int64(result.From.ID), result.From), int64(result.From.ID), result.From, strings.TrimSpace(result.Query))
+	log.Printf("Inline expense of the user %d: %s", userID(result.From), replyText)
+}
+
+func (tm *TableManagement) isInlineUser(user *tgbotapi.User) bool {
+	return user != nil && tm.inlineUsers[user.ID]
+}
+
+func hasSum(expenses []*Expense) bool {
+	for _, expense := range expenses {
+		if expense.Sum != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func userID(user *tgbotapi.User) int {
+	if user == nil {
+		return 0
+	}
+	return user.ID
+}
+
+// parseUserIDs parses a comma separated list of Telegram user IDs
+func parseUserIDs(list string) ([]int, error) {
+	var ids []int
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// LoopbackAuth obtains an OAuth token by redirecting the browser to a temporary local listener.
+// The flow uses PKCE and checks the state parameter to protect the authorization code.
+type LoopbackAuth struct {
+	config  *oauth2.Config
+	address string
+	timeout time.Duration
+}
+
+type authResult struct {
+	code string
+	err  error
+}
+
+// NewLoopbackAuth creates new LoopbackAuth instant listening on the address, e.g. 127.0.0.1:0
+func NewLoopbackAuth(config *oauth2.Config, address string, timeout time.Duration) *LoopbackAuth {
+	return &LoopbackAuth{config: config, address: address, timeout: timeout}
+}
+
+// Token runs the authorization flow and exchanges the received code for a token
+func (la *LoopbackAuth) Token(ctx context.Context) (*oauth2.Token, error) {
+	listener, err := net.Listen("tcp", la.address)
+	if err != nil {
+		return nil, fmt.Errorf("could not start loopback listener: %v", err)
+	}
+	defer listener.Close()
+	state, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	config := *la.config
+	config.RedirectURL = fmt.Sprintf("http://%s/callback", listener.Addr().String())
+	results := make(chan authResult, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("state") != state {
+			// Do not let a stray or forged request abort the flow
+			http.Error(w, "state mismatch", http.StatusBadRequest)
+			return
+		}
+		result := la.readCallback(r)
+		if result.err != nil {
+			http.Error(w, result.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "Authorization is complete, you can close this window.")
+		}
+		select {
+		case results <- result:
+		default:
+		}
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer server.Close()
+
+	authURL := config.AuthCodeURL(state, oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+	fmt.Printf("Go to the following link in your browser to authorize the bot:\n%v\n", authURL)
+
+	ctx, cancel := context.WithTimeout(ctx, la.timeout)
+	defer cancel()
+	var result authResult
+	select {
+	case result = <-results:
+	case <-ctx.Done():
+		return nil, errors.New("authorization was not completed in time")
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+	token, err := config.Exchange(ctx, result.code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	if err != nil {
+		log.Printf("Unable to retrieve token from web: %v", err)
+		return nil, err
+	}
+	return token, nil
+}
+
+func (la *LoopbackAuth) readCallback(r *http.Request) authResult {
+	query := r.URL.Query()
+	if reason := query.Get("error"); reason != "" {
+		return authResult{err: fmt.Errorf("authorization was denied: %s", reason)}
+	}
+	code := query.Get("code")
+	if code == "" {
+		return authResult{err: errors.New("authorization code is missing")}
+	}
+	return authResult{code: code}
+}
+
+func randomString(size int) (string, error) {
+	bytes := make([]byte, size)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
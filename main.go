@@ -1,105 +1,444 @@
 package main
 
 import (
-	"log"
+	"context"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
-func configure() (*tgbotapi.BotAPI, tgbotapi.UpdatesChannel, *TableManagement) {
-	token := os.Getenv("TELEGRAM_TOKEN")
-	bot, err := tgbotapi.NewBotAPI(token)
+// application holds everything the main loop needs
+type application struct {
+	bot           *tgbotapi.BotAPI
+	tm            *TableManagement
+	store         *UpdateStore
+	recorder      *Recorder
+	updates       tgbotapi.UpdatesChannel
+	server        *http.Server
+	stopReceiving func()
+	// schedule ticks when the recurring expenses and the goals are due to be checked, nil when they are off
+	schedule <-chan time.Time
+}
+
+func newTableManagement(config *Configuration, recorder *Recorder) *TableManagement {
+	spreadsheets, _ := config.Spreadsheets()
+	properties := config.ConnectionProperties()
+	properties.SpreadsheetID = activeSpreadsheet(spreadsheets, config.Now().Year())
+	if recorder != nil {
+		properties.WrapTransport = recorder.Transport
+	}
+	tableService, err := NewTableService(properties, NewFileTokenStore(config.TokenFile))
+	if err != nil {
+		log.Fatalf("Could not connect to the spreadsheet: %v", err)
+	}
+	for year, spreadsheetID := range spreadsheets {
+		if err := tableService.ForSpreadsheet(spreadsheetID).Check(); err != nil {
+			log.Fatalf("Spreadsheet of %d is not reachable: %v", year, err)
+		}
+	}
+	managementProperties, err := newManagementProperties(config, spreadsheets)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if now := config.Now(); now.Month() == time.December {
+		if _, ok := spreadsheets[now.Year()+1]; !ok {
+			log.Printf("There is no spreadsheet of %d yet, add it to SHEET_IDS", now.Year()+1)
+		}
+	}
+	return NewTableManagement(tableService, managementProperties)
+}
+
+// newManagementProperties builds the properties of the bot from the configuration and loads the saved state
+func newManagementProperties(config *Configuration, spreadsheets map[int]string) (*ManagementProperties, error) {
+	location, err := loadLocation(config.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	managementProperties := &ManagementProperties{
+		ConfirmThreshold: config.ConfirmThreshold,
+		ConfirmTimeout:   config.ConfirmTimeout,
+		Spreadsheets:     spreadsheets,
+		Location:         location,
+		DayEndsAt:        config.DayEndsAt,
+	}
+	managementProperties.InlineUsers, _ = parseUserIDs(config.InlineUsers)
+	managementProperties.OwnerUsers, _ = parseUserIDs(config.OwnerUsers)
+	managementProperties.Language = config.Language
+	managementProperties.Chats, err = NewChatSettingsStore(config.ChatSettingsFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load chat settings: %v", err)
+	}
+	managementProperties.Recurring, err = NewRecurringExpenses(config.RecurringFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load recurring expenses: %v", err)
+	}
+	managementProperties.Ledger, err = NewSplitLedger(config.LedgerFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load split ledger: %v", err)
+	}
+	managementProperties.AuthorMode = config.AuthorMode
+	managementProperties.AuthorNames, _ = parseAuthorNames(config.AuthorNames)
+	managementProperties.AuthorTotals, err = NewAuthorTotals(config.AuthorTotalsFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load author totals: %v", err)
+	}
+	managementProperties.Goals, err = NewSavingsGoals(config.GoalsFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load savings goals: %v", err)
+	}
+	managementProperties.QuickAdd, err = NewQuickAdd(config.QuickAddFile, config.QuickAddButtons, config.QuickAddRefresh)
+	if err != nil {
+		return nil, fmt.Errorf("could not load quick add file: %v", err)
+	}
+	if config.CurrencyRatesFile != "" {
+		managementProperties.Rates, err = LoadCurrencyRates(config.CurrencyRatesFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load currency rates: %v", err)
+		}
+		log.Printf("Currency rates on %s are loaded", managementProperties.Rates.Date)
+	}
+	return managementProperties, nil
+}
+
+// activeSpreadsheet returns the spreadsheet of the current year, the latest one when it is missing
+func activeSpreadsheet(spreadsheets map[int]string, year int) string {
+	if spreadsheetID, ok := spreadsheets[year]; ok {
+		return spreadsheetID
+	}
+	latest := 0
+	for other := range spreadsheets {
+		if other > latest {
+			latest = other
+		}
+	}
+	log.Printf("There is no spreadsheet of %d, the one of %d is used for checks", year, latest)
+	return spreadsheets[latest]
+}
+
+func configure(config *Configuration, health *HealthService) *application {
+	bot, err := NewTelegramBot(config.TelegramToken, config.TelegramAPIURL)
 	if err != nil {
 		log.Fatalf("Could not connect to telegram: %v", err)
 	}
-	if debug, _ := strconv.ParseBool(os.Getenv("ENABLE_DEBUG")); debug == true {
-		bot.Debug = true
-	}
-	properties := &ConnectionProperties{
-		SpreadsheetID: os.Getenv("SHEET_ID"),
-		ClientID:      os.Getenv("GOOGLE_CLIENT_ID"),
-		ProjectID:     os.Getenv("GOOGLE_PROJECT_ID"),
-		AuthURI:       os.Getenv("GOOGLE_AUTH_URI"),
-		TokenURI:      os.Getenv("GOOGLE_TOKEN_URI"),
-		ClientSecret:  os.Getenv("GOOGLE_CLIENT_SECRET"),
-		RedirectUris:  os.Getenv("GOOGLE_REDIRECT_URIS"),
-		AccessToken:   os.Getenv("SHEET_ACCESS_TOKEN"),
-		TokenType:     os.Getenv("SHEET_TOKEN_TYPE"),
-		RefreshToken:  os.Getenv("SHEET_REFRESH_TOKEN"),
-		ExpireTime:    os.Getenv("SHEET_TOKEN_EXPIRE_TIME"),
-	}
-	tableService, err := NewTableService(properties)
-	tableManagement := NewTableManagement(tableService)
-	if "heroku" == os.Getenv("ENVIRONMENT") {
-		bot.RemoveWebhook()
-		publicURL := fmt.Sprintf("%s/%s", os.Getenv("URL"), token)
-		_, err = bot.SetWebhook(tgbotapi.NewWebhook(publicURL))
+	bot.Debug = config.Debug
+	store, err := NewUpdateStore(config.UpdateStoreFile, config.UpdateWindow)
+	if err != nil {
+		log.Fatalf("Could not load processed updates: %v", err)
+	}
+	app := &application{bot: bot, store: store}
+	if config.RecordFile != "" {
+		spreadsheets, _ := config.Spreadsheets()
+		var spreadsheetIDs []string
+		for _, spreadsheetID := range spreadsheets {
+			spreadsheetIDs = append(spreadsheetIDs, spreadsheetID)
+		}
+		app.recorder, err = NewRecorder(config.RecordFile, spreadsheetIDs, config.Secrets()...)
+		if err != nil {
+			log.Fatalf("Could not start recording: %v", err)
+		}
+		log.Printf("Recording updates and Sheets responses to %s", config.RecordFile)
+	}
+	app.tm = newTableManagement(config, app.recorder)
+	app.tm.commands.BotName = bot.Self.UserName
+	if config.RecurringInterval > 0 {
+		app.schedule = time.NewTicker(config.RecurringInterval).C
+	}
+	health.Register(http.DefaultServeMux)
+	if config.IsWebhook() {
+		webhook, err := NewWebhookService(config.WebhookProperties(), bot.Buffer)
+		if err != nil {
+			log.Fatalf("Could not create webhook: %v", err)
+		}
+		app.updates, err = webhook.Register(bot, http.DefaultServeMux)
 		if err != nil {
 			log.Fatalf("Could not register webhook: %v", err)
 		}
-		updates := bot.ListenForWebhook("/" + token)
-		go http.ListenAndServe("0.0.0.0:" + os.Getenv("PORT"), nil)
-		return bot, updates, tableManagement
+		app.server = &http.Server{Addr: webhook.Address()}
+		go func() {
+			if err := webhook.Serve(app.server); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Could not start http server: %v", err)
+			}
+		}()
+		app.stopReceiving = func() {}
+		return app
 	}
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
-	updates, err := bot.GetUpdatesChan(u)
-	if err != nil {
-		log.Fatal("Could not init a connection to Telegram", err)
+	if config.Port != "" {
+		app.server = &http.Server{Addr: net.JoinHostPort(config.BindAddress, config.Port)}
+		go func() {
+			if err := app.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Could not start http server: %v", err)
+			}
+		}()
 	}
-	return bot, updates, tableManagement
+	poller := NewPoller(bot, store, 60)
+	app.updates = poller.Start()
+	app.stopReceiving = poller.Stop
+	return app
 }
 
-func processCommand(tm *TableManagement, update *tgbotapi.Update) tgbotapi.MessageConfig {
-	balance, err := tm.GetTableBalance(update.Message.Command())
+func processUpdate(tm *TableManagement, update *tgbotapi.Update) tgbotapi.MessageConfig {
+	chatID := update.Message.Chat.ID
+	l := tm.localizer(chatID, update.Message.From)
+	expenses, err := tm.parseExpenses(update.Message.Text)
 	if err != nil {
-		log.Printf("Following error accured: %v", err)
-		return tgbotapi.NewMessage(update.Message.Chat.ID, "Some error accured")
+		return tgbotapi.NewMessage(chatID, errorText(l, err))
 	}
-	return tgbotapi.NewMessage(update.Message.Chat.ID, balance)
+	var replyMessage tgbotapi.MessageConfig
+	if tm.pending.NeedsConfirmation(expenses) {
+		replyMessage = tgbotapi.NewMessage(chatID, l.Text("confirm", tm.formatExpenses(expenses)))
+		replyMessage.ReplyMarkup = tm.pending.Hold(l, chatID, userID(update.Message.From), update.Message.MessageID, update.Message.Text, tm.now())
+	} else {
+		replyText, expenses := recordExpenses(tm, l, chatID, update.Message.From, update.Message.Text)
+		if year, ok := tm.missingNextYear(chatID); ok {
+			replyText += "\n\n" + l.Text("nextYearMissing", year)
+		}
+		replyMessage = tgbotapi.NewMessage(chatID, replyText)
+		if keyboard, ok := tm.quickAdd.Learn(chatID, expenses, tm.now()); ok {
+			replyMessage.ReplyMarkup = keyboard
+		}
+	}
+	replyMessage.ReplyToMessageID = update.Message.MessageID
+	return replyMessage
 }
 
-func processUpdate(tm *TableManagement, update *tgbotapi.Update) tgbotapi.MessageConfig {
-	_, err := tm.UpdateTableData(update.Message.Text)
+// processCallback handles the confirmation keyboard, the question is replaced with the result.
+// The edit has no text when the question stays, e.g. when someone else pressed the button.
+func processCallback(tm *TableManagement, callback *tgbotapi.CallbackQuery) (tgbotapi.CallbackConfig, tgbotapi.EditMessageTextConfig) {
+	chatID := callback.Message.Chat.ID
+	tm = tm.forChat(chatID)
+	l := tm.localizer(chatID, callback.From)
+	action, text, err := tm.pending.Take(chatID, userID(callback.From), callback.Data, tm.now())
+	var replyText string
+	switch {
+	case err == errForeignConfirmation:
+		log.Printf("User %d pressed the confirmation of another user in the chat %d", userID(callback.From), chatID)
+		return tgbotapi.NewCallbackWithAlert(callback.ID, l.Text("notYourExpense")), tgbotapi.EditMessageTextConfig{}
+	case err != nil:
+		replyText = l.Text("confirmExpired")
+	case action == confirmCallback:
+		replyText, _ = recordExpenses(tm, l, chatID, callback.From, text)
+	default:
+		replyText = l.Text("cancelled")
+	}
+	return tgbotapi.NewCallback(callback.ID, ""), tgbotapi.NewEditMessageText(chatID, callback.Message.MessageID, replyText)
+}
+
+// recordExpenses writes the expenses of the text and returns the reply with the written expenses.
+// The lines with mentions are split: the user's share is written and the members mentioned in the line owe theirs.
+func recordExpenses(tm *TableManagement, l *Localizer, chatID int64, user *tgbotapi.User, text string) (string, []*Expense) {
+	payer := memberName(user)
+	author := tm.authorName(user)
+	expenses, err := tm.UpdateTableData(text, author, payer)
 	if err != nil {
-		log.Printf("Following error accured: %v", err)
-		return tgbotapi.NewMessage(update.Message.Chat.ID, "Some error accured")
+		return errorText(l, err), nil
+	}
+	tm.authorTotals.Add(chatID, author, expenses)
+	for _, expense := range expenses {
+		if len(expense.Members) > 0 {
+			tm.ledger.Add(chatID, payer, expense.Members, expense.Sum)
+		}
 	}
 	balance, err := tm.GetTableBalance("db")
 	var replyText string
 	if err != nil {
-		replyText = "Баланс обновлен"
+		replyText = l.Text("balanceUpdated")
 	} else {
-		replyText = "Остаток на день " + balance
+		replyText = l.Text("dailyBalance", l.Roubles(balance))
+	}
+	if len(expenses) > 1 {
+		recorded := l.Text("recorded", len(expenses), l.Plural(float64(len(expenses)), "expense"))
+		replyText = recorded + "\n" + tm.formatExpenses(expenses) + "\n" + replyText
+	}
+	for _, expense := range expenses {
+		if len(expense.Members) > 0 {
+			replyText += "\n" + l.Text("split", len(expense.Members)+1, strings.Join(expense.Members, ", "))
+		}
 	}
 	log.Print("Ok: ", replyText)
-	replyMessage := tgbotapi.NewMessage(update.Message.Chat.ID, replyText)
-	replyMessage.ReplyToMessageID = update.Message.MessageID
-	return replyMessage
+	return replyText, expenses
+}
+
+// errorText explains the parsing errors to the user and hides the rest
+func errorText(l *Localizer, err error) string {
+	switch err := err.(type) {
+	case *AmountError:
+		return l.Text("invalidAmount", err.Word)
+	case *DateError:
+		return l.Text("invalidDate", err.Word)
+	case *YearError:
+		return l.Text("noSpreadsheet", err.Year)
+	case *RateError:
+		return l.Text("noRate", err.Currency)
+	case *EmptyCellError:
+		return l.Text("noData")
+	default:
+		log.Printf("Following error accured: %v", err)
+		return l.Text("error")
+	}
+}
+
+// reply builds the answer to the message the same way for Telegram, the terminal and replays
+func reply(tm *TableManagement, update *tgbotapi.Update) tgbotapi.MessageConfig {
+	tm = tm.forChat(update.Message.Chat.ID)
+	if replyText, ok := tm.commands.Route(tm, update.Message); ok {
+		return tgbotapi.NewMessage(update.Message.Chat.ID, replyText)
+	}
+	return processUpdate(tm, update)
+}
+
+func handleUpdate(app *application, update *tgbotapi.Update) {
+	if app.store.Seen(update) {
+		log.Printf("Skipping already processed update %d", update.UpdateID)
+		confirmUpdate(app.store, update)
+		return
+	}
+	if app.recorder != nil {
+		app.recorder.RecordUpdate(update, app.tm.now())
+	}
+	if update.Message != nil {
+		if replyMessage := reply(app.tm, update); replyMessage.Text != "" {
+			app.bot.Send(replyMessage)
+		}
+	}
+	if update.CallbackQuery != nil && update.CallbackQuery.Message != nil {
+		answer, edit := processCallback(app.tm, update.CallbackQuery)
+		app.bot.AnswerCallbackQuery(answer)
+		if edit.Text != "" {
+			app.bot.Send(edit)
+		}
+	}
+	if update.InlineQuery != nil {
+		if _, err := app.bot.AnswerInlineQuery(processInlineQuery(app.tm, update.InlineQuery)); err != nil {
+			log.Printf("Could not answer inline query: %v", err)
+		}
+	}
+	if update.ChosenInlineResult != nil {
+		processChosenInlineResult(app.tm, update.ChosenInlineResult)
+	}
+	confirmUpdate(app.store, update)
+}
+
+// confirmUpdate moves the offset past the update, a skipped update is confirmed too so it is not received again
+func confirmUpdate(store *UpdateStore, update *tgbotapi.Update) {
+	if err := store.Confirm(update); err != nil {
+		log.Printf("Could not confirm update %d: %v", update.UpdateID, err)
+	}
+}
+
+// runSchedule writes the due recurring expenses of every chat, reminds of the goals falling behind
+// and notifies the chats
+func runSchedule(app *application) {
+	for _, chatID := range app.tm.recurring.Chats() {
+		if replyText, ok := app.tm.writeRecurring(chatID); ok {
+			app.bot.Send(tgbotapi.NewMessage(chatID, replyText))
+		}
+	}
+	for _, chatID := range app.tm.goals.Chats() {
+		app.tm.remindGoals(chatID, func(text string) error {
+			_, err := app.bot.Send(tgbotapi.NewMessage(chatID, text))
+			return err
+		})
+	}
+}
+
+// serve processes updates until quit is closed, then drains the already received ones.
+// The scheduled jobs run in the same loop, so the recurring expenses never race with the messages for a cell.
+func serve(app *application, quit <-chan struct{}) {
+	if app.schedule != nil {
+		runSchedule(app)
+	}
+	for {
+		select {
+		case update := <-app.updates:
+			handleUpdate(app, &update)
+		case <-app.schedule:
+			runSchedule(app)
+		case <-quit:
+			for {
+				select {
+				case update := <-app.updates:
+					handleUpdate(app, &update)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func runAuth(args []string) {
+	config, err := LoadConfiguration(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	store := NewFileTokenStore(config.TokenFile)
+	if err := Authorize(config.ConnectionProperties(), store, config.AuthAddress, 5*time.Minute); err != nil {
+		log.Fatalf("Could not authorize: %v", err)
+	}
+	log.Printf("Token is saved to %s", config.TokenFile)
 }
 
 func main() {
-	bot, updates, tm := configure()
-	log.Printf("Authorized on account %s", bot.Self.UserName)
-	var lastProcessedMessageID int
-	for update := range updates {
-		if update.Message == nil {
-			continue
-		}
-		if lastProcessedMessageID == update.Message.MessageID {
-			continue
-		}
-		var replyMessage tgbotapi.MessageConfig
-		if update.Message.IsCommand() {
-			replyMessage = processCommand(tm, &update)
-		} else {
-			replyMessage = processUpdate(tm, &update)
-		}
-		bot.Send(replyMessage)
-		lastProcessedMessageID = update.Message.MessageID
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "auth":
+			runAuth(os.Args[2:])
+			return
+		case "replay":
+			runReplay(os.Args[2:])
+			return
+		case "add", "balance", "history", "repl":
+			runCLI(os.Args[1], os.Args[2:])
+			return
+		case "help", "-h", "-help", "--help":
+			fmt.Println(cliUsage)
+			return
+		}
+	}
+	config, err := LoadConfiguration(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := config.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	health := NewHealthService()
+	app := configure(config, health)
+	log.Printf("Authorized on account %s", app.bot.Self.UserName)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		serve(app, quit)
+		close(done)
+	}()
+	health.SetReady(true)
+
+	received := <-signals
+	log.Printf("Received %v, shutting down", received)
+	health.SetReady(false)
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if app.server != nil {
+		if err := app.server.Shutdown(ctx); err != nil {
+			log.Printf("Could not stop http server: %v", err)
+		}
+	}
+	app.stopReceiving()
+	close(quit)
+	select {
+	case <-done:
+		log.Print("All updates are processed")
+	case <-ctx.Done():
+		log.Print("Shutdown deadline exceeded, in-flight updates are dropped")
 	}
 }
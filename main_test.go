@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+
+	"github.com/burnout171/tinkoff-table-bot/telegramtest"
+)
+
+func TestServeThroughBotAPI(t *testing.T) {
+	telegram := telegramtest.NewServer("123:token")
+	defer telegram.Close()
+	bot, err := NewTelegramBot("123:token", telegram.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm, sheets := newSheetsManagement(t)
+	defer sheets.Close()
+	dir, err := ioutil.TempDir("", "updates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewUpdateStore(filepath.Join(dir, "updates.json"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	poller := NewPoller(bot, store, 1)
+	defer poller.Stop()
+	app := &application{bot: bot, tm: tm, store: store, updates: poller.Start()}
+
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		serve(app, quit)
+		close(done)
+	}()
+
+	message := telegram.SendText(42, tgbotapi.User{ID: 7, FirstName: "Аня"}, "кофе 150")
+	sent := telegram.WaitSent(1, 5*time.Second)
+	close(quit)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not stop")
+	}
+	if len(sent) != 1 {
+		t.Fatalf("sent %d items, want 1", len(sent))
+	}
+	if sent[0].ChatID != 42 || sent[0].ReplyTo != message.Message.MessageID || sent[0].Text != "Остаток на день 850 рублей" {
+		t.Errorf("sent %+v, want the daily balance in reply to the message", sent[0])
+	}
+	if offset, _ := store.Confirmed(); offset != message.UpdateID+1 {
+		t.Errorf("offset = %d, want %d", offset, message.UpdateID+1)
+	}
+}
+
+func TestTelegramAPIURLWithPath(t *testing.T) {
+	telegram := telegramtest.NewServer("123:token")
+	defer telegram.Close()
+	target, err := url.Parse(telegram.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(http.StripPrefix("/telegram", httputil.NewSingleHostReverseProxy(target)))
+	defer proxy.Close()
+
+	// NewBotAPIWithClient calls getMe, so the bot is created only when the prefix reaches the proxy
+	if _, err := NewTelegramBot("123:token", proxy.URL+"/telegram/"); err != nil {
+		t.Fatalf("NewTelegramBot() with a path = %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"strings"
 	"strconv"
 	"time"
@@ -9,15 +10,104 @@ import (
 	"google.golang.org/api/sheets/v4"
 )
 
+// ManagementProperties holds settings of the expenses processing
+type ManagementProperties struct {
+	Rates            *CurrencyRates
+	ConfirmThreshold float64
+	ConfirmTimeout   time.Duration
+	QuickAdd         *QuickAdd
+	InlineUsers      []int
+	OwnerUsers       []int
+	Language         string
+	Chats            *ChatSettingsStore
+	Spreadsheets     map[int]string
+	Location         *time.Location
+	DayEndsAt        int
+	Recurring        *RecurringExpenses
+	Ledger           *SplitLedger
+	AuthorMode       string
+	AuthorNames      map[int]string
+	AuthorTotals     *AuthorTotals
+	Goals            *SavingsGoals
+}
+
 // TableManagement manages update and get table data commands
 type TableManagement struct {
-	ts *TableService
+	ts           *TableService
+	now          func() time.Time
+	rates        *CurrencyRates
+	pending      *PendingExpenses
+	quickAdd     *QuickAdd
+	inlineUsers  map[int]bool
+	owners       map[int]bool
+	commands     *CommandRouter
+	language     string
+	chats        *ChatSettingsStore
+	spreadsheets map[int]string
+	warned       map[int64]string
+	location     *time.Location
+	dayEndsAt    int
+	recurring    *RecurringExpenses
+	ledger       *SplitLedger
+	authorMode   string
+	authors      map[int]string
+	authorTotals *AuthorTotals
+	goals        *SavingsGoals
 }
 
 // NewTableManagement creates new TableManagement instant
-func NewTableManagement(ts *TableService) *TableManagement {
+func NewTableManagement(ts *TableService, properties *ManagementProperties) *TableManagement {
 	tm := &TableManagement{}
 	tm.ts = ts
+	tm.now = time.Now
+	tm.rates = properties.Rates
+	if tm.rates == nil {
+		tm.rates = NewCurrencyRates()
+	}
+	tm.pending = NewPendingExpenses(properties.ConfirmThreshold, properties.ConfirmTimeout)
+	tm.quickAdd = properties.QuickAdd
+	if tm.quickAdd == nil {
+		tm.quickAdd, _ = NewQuickAdd("", 0, 0)
+	}
+	tm.inlineUsers = make(map[int]bool)
+	for _, id := range properties.InlineUsers {
+		tm.inlineUsers[id] = true
+	}
+	tm.owners = make(map[int]bool)
+	for _, id := range properties.OwnerUsers {
+		tm.owners[id] = true
+	}
+	tm.commands = NewCommandRouter()
+	tm.language = properties.Language
+	tm.chats = properties.Chats
+	tm.spreadsheets = properties.Spreadsheets
+	tm.warned = make(map[int64]string)
+	tm.location = properties.Location
+	if tm.location == nil {
+		tm.location = time.Local
+	}
+	tm.dayEndsAt = properties.DayEndsAt
+	tm.recurring = properties.Recurring
+	if tm.recurring == nil {
+		tm.recurring, _ = NewRecurringExpenses("")
+	}
+	tm.ledger = properties.Ledger
+	if tm.ledger == nil {
+		tm.ledger, _ = NewSplitLedger("")
+	}
+	tm.authorMode = properties.AuthorMode
+	tm.authors = properties.AuthorNames
+	tm.authorTotals = properties.AuthorTotals
+	if tm.authorTotals == nil {
+		tm.authorTotals, _ = NewAuthorTotals("")
+	}
+	tm.goals = properties.Goals
+	if tm.goals == nil {
+		tm.goals, _ = NewSavingsGoals("")
+	}
+	if tm.chats == nil {
+		tm.chats, _ = NewChatSettingsStore("")
+	}
 	return tm
 }
 
@@ -27,109 +117,355 @@ func (tm *TableManagement) GetTableBalance(command string) (string, error) {
 	case "db":
 		return tm.getDailyBalance()
 	case "mb":
-		return tm.getMonthlyBalance()
+		month, _ := tm.currentDate()
+		return tm.getMonthlyBalance(tm.now().Year(), month)
 	case "ma":
-		return tm.getMonthlyAccumulation()
+		month, _ := tm.currentDate()
+		return tm.getMonthlyAccumulation(tm.now().Year(), month)
 	default:
-		return "Unknown command", nil
+		return "", fmt.Errorf("unknown balance %s", command)
 	}
 }
 
-// UpdateTableData updates daily balance and returns its new value
-func (tm *TableManagement) UpdateTableData(input string) (int64, error) {
-	receivedKey, sum := tm.parseInput(input)
-	month, day := tm.currentDate()
-	workingRange := fmt.Sprintf("%s!H%d:I%d", month, day+1, day+1)
-	receivedRange, err := tm.ts.GetData(workingRange)
+// UpdateTableData records every line of the input as an expense and returns the recorded expenses.
+// All the expenses are written in one batch per spreadsheet, the ones of the same day are merged into its cells.
+// A line with mentions is shared by the payer with the mentioned members and recorded as the payer's share.
+func (tm *TableManagement) UpdateTableData(input string, author string, payer string) ([]*Expense, error) {
+	expenses, err := tm.parseExpenses(input)
+	if err != nil {
+		return nil, err
+	}
+	for _, expense := range expenses {
+		expense.Author = author
+		expense.Members = tm.shareMembers(expense.Members, payer)
+		if len(expense.Members) > 0 {
+			expense.Sum = shareOf(expense.Sum, len(expense.Members)+1)
+		}
+	}
+	if err := tm.writeByYear(expenses); err != nil {
+		return nil, err
+	}
+	return expenses, nil
+}
+
+// writeByYear writes the expenses to the spreadsheets of their years
+func (tm *TableManagement) writeByYear(expenses []*Expense) error {
+	var err error
+	// Resolve every spreadsheet first so nothing is written when a year has none
+	var years []int
+	byYear := make(map[int][]*Expense)
+	tables := make(map[int]*TableService)
+	for _, expense := range expenses {
+		year := expense.Date.Year()
+		if _, ok := tables[year]; !ok {
+			if tables[year], err = tm.tableFor(year); err != nil {
+				return err
+			}
+			years = append(years, year)
+		}
+		byYear[year] = append(byYear[year], expense)
+	}
+	for _, year := range years {
+		if err := tm.writeExpenses(tables[year], byYear[year]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeExpenses adds the expenses to the cells of their days in the spreadsheet
+func (tm *TableManagement) writeExpenses(ts *TableService, expenses []*Expense) error {
+	var workingRanges []string
+	cells := make(map[string]*dayCell)
+	for _, expense := range expenses {
+		month, day := tm.dateOf(expense.Date)
+		workingRange := fmt.Sprintf("%s!H%d:I%d", month, day+1, day+1)
+		if _, ok := cells[workingRange]; !ok {
+			workingRanges = append(workingRanges, workingRange)
+			cells[workingRange] = nil
+		}
+	}
+	receivedRanges, err := ts.BatchGetData(workingRanges)
 	if err != nil {
-		return -1, err
+		return err
+	}
+	for i, workingRange := range workingRanges {
+		cell := &dayCell{}
+		if i < len(receivedRanges) && len(receivedRanges[i].Values) > 0 {
+			row := receivedRanges[i].Values[0]
+			cell.exists = true
+			if len(row) > 0 {
+				cell.key = fmt.Sprint(row[0])
+			}
+			if len(row) > 1 && row[1] != "" {
+				if cell.value, err = sheetNumber(row[1]); err != nil {
+					return fmt.Errorf("could not read the sum of %s: %v", workingRange, err)
+				}
+			}
+		}
+		cells[workingRange] = cell
+	}
+	for _, expense := range expenses {
+		month, day := tm.dateOf(expense.Date)
+		cell := cells[fmt.Sprintf("%s!H%d:I%d", month, day+1, day+1)]
+		description := tm.attribute(expense.Description, expense.Author)
+		if cell.exists {
+			cell.key = tm.prepareKey(description, cell.key)
+		} else {
+			cell.key = description
+		}
+		cell.exists = true
+		cell.value += expense.Sum
+	}
+	var resultRanges []*sheets.ValueRange
+	for _, workingRange := range workingRanges {
+		cell := cells[workingRange]
+		resultRanges = append(resultRanges, &sheets.ValueRange{
+			Range:  workingRange,
+			Values: [][]interface{}{{strings.ToLower(cell.key), cell.value}},
+		})
+	}
+	_, err = ts.BatchUpdateData(resultRanges)
+	return err
+}
+
+// dayCell holds the description and the sum cells of a day
+type dayCell struct {
+	exists bool
+	key    string
+	value  float64
+}
+
+// YearError is returned for a year which has no spreadsheet
+type YearError struct {
+	Year int
+}
+
+func (ye *YearError) Error() string {
+	return fmt.Sprintf("no spreadsheet for %d", ye.Year)
+}
+
+// EmptyCellError is returned when a balance cell has no value, e.g. for a month which is not filled yet
+type EmptyCellError struct {
+	Range string
+}
+
+func (ee *EmptyCellError) Error() string {
+	return fmt.Sprintf("no value in %s", ee.Range)
+}
+
+// tableFor returns the service of the year spreadsheet, the only one when no years are configured
+func (tm *TableManagement) tableFor(year int) (*TableService, error) {
+	if len(tm.spreadsheets) == 0 {
+		return tm.ts, nil
+	}
+	spreadsheetID, ok := tm.spreadsheets[year]
+	if !ok {
+		return nil, &YearError{Year: year}
 	}
-	var resultRange sheets.ValueRange
-	var myValues []interface{}
-	if len(receivedRange.Values) == 0 {
-		myValues = []interface{}{strings.ToLower(receivedKey), sum}
-	} else {
-		key := tm.prepareKey(receivedKey, receivedRange.Values[0][0].(string))
-		value := tm.prepareValue(sum, receivedRange.Values[0][1].(string))
-		myValues = []interface{}{strings.ToLower(key), value}
+	return tm.ts.ForSpreadsheet(spreadsheetID), nil
+}
+
+// hasYear reports whether expenses of the year can be written
+func (tm *TableManagement) hasYear(year int) bool {
+	if len(tm.spreadsheets) == 0 {
+		return year == tm.now().Year()
 	}
-	resultRange.Values = append(resultRange.Values, myValues)
-	updateResponse, err := tm.ts.UpdateData(workingRange, &resultRange)
-	if (err != nil) {
-		return -1, err
+	_, ok := tm.spreadsheets[year]
+	return ok
+}
+
+// missingNextYear returns the next year when it is December and the year has no spreadsheet yet,
+// every chat is warned once a day
+func (tm *TableManagement) missingNextYear(chatID int64) (int, bool) {
+	now := tm.now()
+	if len(tm.spreadsheets) == 0 || now.Month() != time.December || tm.hasYear(now.Year()+1) {
+		return 0, false
 	}
-	return updateResponse.UpdatedCells, nil
+	today := now.Format("2006-01-02")
+	if tm.warned[chatID] == today {
+		return 0, false
+	}
+	tm.warned[chatID] = today
+	return now.Year() + 1, true
 }
 
 func (tm *TableManagement) getDailyBalance() (string, error) {
 	month, day := tm.currentDate()
 	workingRange := fmt.Sprintf("%s!K%d", month, day+1)
-	return tm.getSimpleSheetData(workingRange)
+	return tm.getSimpleSheetData(tm.now().Year(), workingRange)
 }
 
-func (tm *TableManagement) getMonthlyBalance() (string, error) {
-	month, _ := tm.currentDate()
+func (tm *TableManagement) getMonthlyBalance(year int, month string) (string, error) {
 	workingRange := fmt.Sprintf("%s!K33", month)
-	return tm.getSimpleSheetData(workingRange)
+	return tm.getSimpleSheetData(year, workingRange)
 }
 
-func (tm *TableManagement) getMonthlyAccumulation() (string, error) {
-	month, _ := tm.currentDate()
+func (tm *TableManagement) getMonthlyAccumulation(year int, month string) (string, error) {
 	workingRange := fmt.Sprintf("%s!D21", month)
-	return tm.getSimpleSheetData(workingRange)
+	return tm.getSimpleSheetData(year, workingRange)
 }
 
-func (tm *TableManagement) getSimpleSheetData(workingRange string) (string, error) {
-	receivedRange, err := tm.ts.GetData(workingRange)
+// getHistory lists the expenses of the month, the current one up to today, empty when there are none
+func (tm *TableManagement) getHistory(year int, month string) (string, error) {
+	currentMonth, day := tm.currentDate()
+	if month != currentMonth || year != tm.now().Year() {
+		day = 31
+	}
+	ts, err := tm.tableFor(year)
 	if err != nil {
 		return "", err
 	}
-	return receivedRange.Values[0][0].(string), nil
+	workingRange := fmt.Sprintf("%s!H2:I%d", month, day+1)
+	receivedRange, err := ts.GetData(workingRange)
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for i, row := range receivedRange.Values {
+		if len(row) < 2 || row[0] == "" {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%d: %v — %v", i+1, row[0], row[1]))
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (tm *TableManagement) getSimpleSheetData(year int, workingRange string) (string, error) {
+	ts, err := tm.tableFor(year)
+	if err != nil {
+		return "", err
+	}
+	receivedRange, err := ts.GetData(workingRange)
+	if err != nil {
+		return "", err
+	}
+	// The API leaves the values out for an empty cell
+	if len(receivedRange.Values) == 0 || len(receivedRange.Values[0]) == 0 {
+		return "", &EmptyCellError{Range: workingRange}
+	}
+	if value, ok := receivedRange.Values[0][0].(string); ok {
+		return value, nil
+	}
+	return fmt.Sprint(receivedRange.Values[0][0]), nil
 }
 
 func (tm *TableManagement) currentDate() (monthName string, day int) {
-	_, month, day := time.Now().Date()
+	return tm.dateOf(tm.now())
+}
+
+// dateOf returns the sheet name and the day of the date
+func (tm *TableManagement) dateOf(date time.Time) (monthName string, day int) {
+	_, month, day := date.Date()
+	return sheetName(month), day
+}
+
+// sheetName returns the name of the month sheet
+func sheetName(month time.Month) string {
 	switch month {
 	case time.January:
-		return "Январь", day
+		return "Январь"
 	case time.February:
-		return "Февраль", day
+		return "Февраль"
 	case time.March:
-		return "Март", day
+		return "Март"
 	case time.April:
-		return "Апрель", day
+		return "Апрель"
 	case time.May:
-		return "Май", day
+		return "Май"
 	case time.June:
-		return "Июнь", day
+		return "Июнь"
 	case time.July:
-		return "Июль", day
+		return "Июль"
 	case time.August:
-		return "Август", day
+		return "Август"
 	case time.September:
-		return "Сентябрь", day
+		return "Сентябрь"
 	case time.October:
-		return "Октябрь", day
+		return "Октябрь"
 	case time.November:
-		return "Ноябрь", day
+		return "Ноябрь"
 	case time.December:
-		return "Декабрь", day
+		return "Декабрь"
 	default:
-		return "", day
+		return ""
 	}
 }
 
-func (tm *TableManagement) parseInput(input string) (description string, sum float64) {
-	splitted := strings.Split(input, " ")
+func (tm *TableManagement) parseInput(input string) (description string, sum float64, err error) {
+	splitted := splitWords(input)
 	var descriptionSlice []string
-	for _, word := range splitted {
-		if value, err := strconv.ParseFloat(word, 64); err == nil {
+	var foreignAmounts []string
+	var pendingCurrency string
+	for i := 0; i < len(splitted); i++ {
+		parsed, ok, err := parseAmount(splitted[i])
+		if err != nil {
+			return "", 0, err
+		}
+		if !ok {
+			// The currency may be written before the amount as a symbol or a code, e.g. "usd 25"
+			if code, ok := lookupCurrency(splitted[i]); ok && isCurrencyCode(splitted[i]) && i+1 < len(splitted) && tm.isAmount(splitted[i+1]) {
+				pendingCurrency = code
+				continue
+			}
+			descriptionSlice = append(descriptionSlice, splitted[i])
+			continue
+		}
+		value, currency := parsed.value, parsed.currency
+		// The multiplier may be a separate word, e.g. "1,5 тыс"
+		if i+1 < len(splitted) {
+			if multiplier, ok := wordMultipliers[strings.ToLower(splitted[i+1])]; ok {
+				value *= multiplier
+				i++
+			}
+		}
+		// A currency word between two amounts is a unit of the first one, e.g. "мясо 2 фунта 500"
+		if currency == "" && pendingCurrency == "" && i+2 < len(splitted) && !isCurrencyCode(splitted[i+1]) && tm.isAmount(splitted[i+2]) {
+			if _, ok := lookupCurrency(splitted[i+1]); ok {
+				descriptionSlice = append(descriptionSlice, splitted[i], splitted[i+1])
+				i++
+				continue
+			}
+		}
+		if currency == "" && pendingCurrency != "" {
+			currency = pendingCurrency
+		} else if currency == "" && i+1 < len(splitted) {
+			if code, ok := lookupCurrency(splitted[i+1]); ok {
+				currency = code
+				i++
+			}
+		}
+		pendingCurrency = ""
+		if currency == "" || currency == roubles {
 			sum += value
 			continue
 		}
-		descriptionSlice = append(descriptionSlice, word)
+		converted, err := tm.rates.Convert(value, currency)
+		if err != nil {
+			return "", 0, err
+		}
+		sum += math.Round(converted*100) / 100
+		foreignAmounts = append(foreignAmounts, strconv.FormatFloat(value, 'f', -1, 64)+" "+currency)
+	}
+	description = strings.Join(descriptionSlice, ", ")
+	if len(foreignAmounts) > 0 {
+		// Keep the original amounts next to the description, the sum column holds roubles
+		original := strings.Join(foreignAmounts, ", ")
+		if description == "" {
+			description = original
+		} else {
+			description += " (" + original + ")"
+		}
 	}
-	return strings.Join(descriptionSlice, ", "), sum
+	return description, sum, nil
+}
+
+func (tm *TableManagement) isAmount(word string) bool {
+	_, ok, err := parseAmount(word)
+	return ok || err != nil
 }
 
 func (tm *TableManagement) prepareKey(receivedKey string, currentKey string) string {
@@ -142,18 +478,24 @@ func (tm *TableManagement) prepareKey(receivedKey string, currentKey string) str
 	return currentKey + ", " + receivedKey
 }
 
-func (tm *TableManagement) prepareValue(sum float64, currentValue string) float64 {
-	if strings.HasPrefix(currentValue, "SUM") { // In case SUM() function is used in Sheet to sum the exchanges
-		currentValue = currentValue[4 : len(currentValue)-2]
-		for _, number := range strings.Split(currentValue, ", ") {
-			if value, err := strconv.ParseFloat(number, 64); err == nil {
-				sum += value
-				continue
-			}
+// sheetNumber reads the number of a cell, either an unformatted one or one formatted by the sheet, e.g. "12 345,67 ₽"
+func sheetNumber(value interface{}) (float64, error) {
+	if number, ok := value.(float64); ok {
+		return number, nil
+	}
+	var digits strings.Builder
+	for _, r := range fmt.Sprint(value) {
+		if (r >= '0' && r <= '9') || r == ',' || r == '.' || r == '-' {
+			digits.WriteRune(r)
 		}
-		return sum
 	}
-	currentValue = strings.ReplaceAll(currentValue, ",", "")
-	floatValue, _ := strconv.ParseFloat(currentValue, 64)
-	return sum + floatValue
+	text := digits.String()
+	number, err := parseLocaleNumber(strings.TrimPrefix(text, "-"))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", fmt.Sprint(value))
+	}
+	if strings.HasPrefix(text, "-") {
+		return -number, nil
+	}
+	return number, nil
 }
\ No newline at end of file
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/burnout171/tinkoff-table-bot/sheetstest"
+)
+
+func TestSheetNumber(t *testing.T) {
+	tests := []struct {
+		value  interface{}
+		number float64
+	}{
+		{120.5, 120.5},
+		{"120,5", 120.5},
+		{"120.5", 120.5},
+		{"12 345,67 ₽", 12345.67},
+		{"1.200,50", 1200.5},
+		{"-350", -350},
+	}
+	for _, test := range tests {
+		number, err := sheetNumber(test.value)
+		if err != nil || number != test.number {
+			t.Errorf("sheetNumber(%#v) = %v, %v, want %v", test.value, number, err, test.number)
+		}
+	}
+	if _, err := sheetNumber("нет"); err == nil {
+		t.Errorf("sheetNumber(%q) has no error", "нет")
+	}
+}
+
+// newSheetsManagement runs TableManagement against a fake spreadsheet on the 15th of March 2026,
+// the daily balances are recalculated after every write like the template formulas do
+func newSheetsManagement(t *testing.T) (*TableManagement, *sheetstest.Server) {
+	server := sheetstest.NewServer("test", sheetstest.TinkoffTemplate("2026", 1000))
+	server.OnWrite = func(wb *sheetstest.Workbook) {
+		sheet := wb.Sheet("Март")
+		for row := 2; row <= 32; row++ {
+			spent, _ := sheet.Cell(fmt.Sprintf("I%d", row)).(float64)
+			sheet.SetCell(fmt.Sprintf("K%d", row), 1000-spent)
+		}
+	}
+	ts, err := NewTableService(&ConnectionProperties{SpreadsheetID: "test", Endpoint: server.URL()}, nil)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	tm := NewTableManagement(ts, &ManagementProperties{Location: time.UTC})
+	tm.now = func() time.Time { return time.Date(2026, time.March, 15, 12, 0, 0, 0, time.UTC) }
+	return tm, server
+}
+
+func TestUpdateTableData(t *testing.T) {
+	tm, server := newSheetsManagement(t)
+	defer server.Close()
+	server.SetCell("Март!H16", "обед")
+	server.SetCell("Март!I16", 120.5)
+
+	if _, err := tm.UpdateTableData("кофе 150", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if key, value := server.Cell("Март!H16"), server.Cell("Март!I16"); key != "обед, кофе" || value != 270.5 {
+		t.Errorf("day cells = %#v, %#v, want %q, 270.5", key, value, "обед, кофе")
+	}
+	balance, err := tm.GetTableBalance("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != "729.5" {
+		t.Errorf("daily balance = %q, want %q", balance, "729.5")
+	}
+}
+
+func TestEmptyBalanceCell(t *testing.T) {
+	tm, server := newSheetsManagement(t)
+	defer server.Close()
+	server.SetCell("Март!K33", nil)
+
+	if _, err := tm.GetTableBalance("mb"); err == nil {
+		t.Error("empty monthly balance has no error")
+	} else if _, ok := err.(*EmptyCellError); !ok {
+		t.Errorf("empty monthly balance error = %v, want EmptyCellError", err)
+	}
+}
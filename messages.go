@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Languages of the reply texts
+const (
+	russian = "ru"
+	english = "en"
+)
+
+// messages is the catalogue of the reply texts, the arguments are formatted with fmt.Sprintf
+var messages = map[string]map[string]string{
+	russian: {
+		"dailyBalance":      "Остаток на день %s",
+		"monthlyBalance":    "Остаток на месяц %s",
+		"accumulation":      "Накопления %s",
+		"balanceUpdated":    "Баланс обновлен",
+		"recorded":          "Записано %d %s:",
+		"error":             "Произошла ошибка, попробуйте ещё раз",
+		"invalidAmount":     "Не удалось разобрать сумму «%s»",
+		"invalidDate":       "Неверная дата «%s»",
+		"noRate":            "Нет курса %s, расход не записан",
+		"noData":            "В таблице нет данных за этот период",
+		"confirm":           "Проверьте сумму:\n%s\nЗаписать?",
+		"confirmButton":     "Записать",
+		"cancelButton":      "Отмена",
+		"confirmExpired":    "Время подтверждения истекло, отправьте расход ещё раз",
+		"cancelled":         "Отменено",
+		"notYourExpense":    "Подтвердить или отменить расход может только его автор",
+		"inlineConfirm":     "Сумма требует подтверждения",
+		"inlineConfirmText": "Отправьте «%s» боту, чтобы подтвердить",
+		"inlineAdd":         "Записать",
+		"inlineAddText":     "Расход: %s",
+		"noExpenses":        "Нет расходов за %s",
+		"unknownPeriod":     "Неизвестный месяц или год «%s»",
+		"noSpreadsheet":     "Нет таблицы на %d год, добавьте её в SHEET_IDS",
+		"nextYearMissing":   "Таблица на %d год ещё не настроена, добавьте её в SHEET_IDS до 1 января",
+		"unknownCommand":    "Неизвестная команда /%s, список команд: /help",
+		"forbidden":         "Команда недоступна",
+		"usage":             "Использование: %s",
+		"unknownLanguage":   "Доступные языки: ru, en",
+		"language":          "Язык: русский",
+		"start":             "Отправьте расход, например «кофе 150», и он попадёт в таблицу.\n\n%s",
+		"startCommand":      "начать работу с ботом",
+		"helpCommand":       "список команд",
+		"dbCommand":         "остаток на день",
+		"mbCommand":         "остаток на месяц",
+		"maCommand":         "накопления",
+		"historyCommand":    "расходы за месяц",
+		"periodUsage":       "[месяц] [год]",
+		"languageCommand":   "язык ответов",
+		"timezone":          "Часовой пояс: %s, день заканчивается в %02d:00",
+		"unknownTimezone":   "Неизвестный часовой пояс «%s», укажите его как Europe/Moscow",
+		"invalidDayEnd":     "Укажите час от 0 до 12, до которого покупки относятся к предыдущему дню",
+		"timezoneCommand":   "часовой пояс чата",
+		"dayEndCommand":     "час, до которого покупки относятся к предыдущему дню",
+		"recurringCommand":  "регулярные расходы, например аренда или подписки",
+		"recurringUsage":    "add <день> <сумма> <описание> | list | remove <номер>",
+		"recurringEntry":    "%d числа: %s",
+		"recurringAdded":    "Регулярный расход %d: %s",
+		"recurringRemoved":  "Регулярный расход %d удалён",
+		"recurringEmpty":    "Регулярных расходов нет",
+		"unknownRecurring":  "Нет регулярного расхода «%s», номера есть в /recurring list",
+		"invalidMonthDay":   "День «%s» должен быть числом от 1 до 31",
+		"recurringWritten":  "Записаны регулярные расходы, %d %s:",
+		"recurringFailed":   "Не удалось записать регулярные расходы, внесите их вручную:",
+		"split":             "Поделено на %d, остальное должны %s",
+		"debts":             "Долги:",
+		"debt":              "%s → %s: %s",
+		"noDebts":           "Долгов нет",
+		"noDebtsBetween":    "Между %s и %s долгов нет",
+		"settled":           "Долги между %s и %s закрыты",
+		"settledAll":        "Все долги закрыты",
+		"settleCommand":     "кто кому должен",
+		"settleUsage":       "[@участник | all]",
+		"whoTotals":         "Расходы за %s:",
+		"whoCommand":        "расходы за месяц по людям",
+		"goalCommand":       "цели накоплений",
+		"goalUsage":         "add \"Отпуск\" 150000 by 2027-06 | list | remove <номер>",
+		"goalAdded":         "Цель %d: %s, %s к %s",
+		"goalRemoved":       "Цель %d удалена",
+		"unknownGoal":       "Нет цели «%s», номера есть в /goal list",
+		"noGoals":           "Целей нет, добавьте: /goal add \"Отпуск\" 150000 by 2027-06",
+		"goalProgress":      "%d. %s: %s из %s (%d%%)",
+		"goalReached":       "цель достигнута",
+		"goalNeeded":        "нужно %s в месяц до %s",
+		"goalOverdue":       "срок %s прошёл",
+		"goalEstimate":      "при текущем темпе к %s",
+		"goalNoEstimate":    "темп пока не известен",
+		"goalBehind":        "Цель «%s» отстаёт: в среднем откладывается %s в месяц, а нужно %s, чтобы успеть к %s",
+	},
+	english: {
+		"dailyBalance":      "Daily balance %s",
+		"monthlyBalance":    "Monthly balance %s",
+		"accumulation":      "Accumulation %s",
+		"balanceUpdated":    "Balance updated",
+		"recorded":          "Recorded %d %s:",
+		"error":             "Something went wrong, please try again",
+		"invalidAmount":     "Could not read the amount «%s»",
+		"invalidDate":       "Invalid date «%s»",
+		"noRate":            "There is no rate of %s, the expense is not recorded",
+		"noData":            "The sheet has no data for this period",
+		"confirm":           "Check the amount:\n%s\nRecord it?",
+		"confirmButton":     "Record",
+		"cancelButton":      "Cancel",
+		"confirmExpired":    "The confirmation has expired, send the expense again",
+		"cancelled":         "Cancelled",
+		"notYourExpense":    "Only the author of the expense can confirm or cancel it",
+		"inlineConfirm":     "The amount needs a confirmation",
+		"inlineConfirmText": "Send «%s» to the bot to confirm it",
+		"inlineAdd":         "Record",
+		"inlineAddText":     "Expense: %s",
+		"noExpenses":        "No expenses in %s",
+		"unknownPeriod":     "Unknown month or year «%s»",
+		"noSpreadsheet":     "There is no spreadsheet of %d, add it to SHEET_IDS",
+		"nextYearMissing":   "The spreadsheet of %d is not set up yet, add it to SHEET_IDS before 1 January",
+		"unknownCommand":    "Unknown command /%s, see /help",
+		"forbidden":         "The command is not available",
+		"usage":             "Usage: %s",
+		"unknownLanguage":   "Available languages: ru, en",
+		"language":          "Language: English",
+		"start":             "Send an expense, e.g. «coffee 150», and it gets to the sheet.\n\n%s",
+		"startCommand":      "start using the bot",
+		"helpCommand":       "list of commands",
+		"dbCommand":         "daily balance",
+		"mbCommand":         "monthly balance",
+		"maCommand":         "accumulation",
+		"historyCommand":    "expenses of the month",
+		"periodUsage":       "[month] [year]",
+		"languageCommand":   "language of the replies",
+		"timezone":          "Timezone: %s, the day ends at %02d:00",
+		"unknownTimezone":   "Unknown timezone «%s», set it like Europe/Moscow",
+		"invalidDayEnd":     "Set the hour from 0 to 12 until which purchases belong to the previous day",
+		"timezoneCommand":   "timezone of the chat",
+		"dayEndCommand":     "hour until which purchases belong to the previous day",
+		"recurringCommand":  "recurring expenses, e.g. the rent or subscriptions",
+		"recurringUsage":    "add <day> <amount> <description> | list | remove <number>",
+		"recurringEntry":    "on the %d: %s",
+		"recurringAdded":    "Recurring expense %d: %s",
+		"recurringRemoved":  "Recurring expense %d is removed",
+		"recurringEmpty":    "There are no recurring expenses",
+		"unknownRecurring":  "There is no recurring expense «%s», see the numbers in /recurring list",
+		"invalidMonthDay":   "The day «%s» must be a number from 1 to 31",
+		"recurringWritten":  "Recurring expenses are recorded, %d %s:",
+		"recurringFailed":   "Could not record the recurring expenses, please enter them by hand:",
+		"split":             "Split between %d, %s owe the rest",
+		"debts":             "Debts:",
+		"debt":              "%s → %s: %s",
+		"noDebts":           "There are no debts",
+		"noDebtsBetween":    "%s and %s owe each other nothing",
+		"settled":           "The debts between %s and %s are settled",
+		"settledAll":        "All the debts are settled",
+		"settleCommand":     "who owes whom",
+		"settleUsage":       "[@member | all]",
+		"whoTotals":         "Expenses in %s:",
+		"whoCommand":        "expenses of the month per person",
+		"goalCommand":       "savings goals",
+		"goalUsage":         "add \"Vacation\" 150000 by 2027-06 | list | remove <number>",
+		"goalAdded":         "Goal %d: %s, %s by %s",
+		"goalRemoved":       "Goal %d is removed",
+		"unknownGoal":       "There is no goal «%s», see the numbers in /goal list",
+		"noGoals":           "There are no goals, add one: /goal add \"Vacation\" 150000 by 2027-06",
+		"goalProgress":      "%d. %s: %s of %s (%d%%)",
+		"goalReached":       "the goal is reached",
+		"goalNeeded":        "%s a month is needed by %s",
+		"goalOverdue":       "the deadline %s has passed",
+		"goalEstimate":      "at the current pace by %s",
+		"goalNoEstimate":    "the pace is not known yet",
+		"goalBehind":        "The goal «%s» falls behind: %s a month is saved on average, but %s is needed to make it by %s",
+	},
+}
+
+// plurals are the forms of the counted words: one, few and many for Russian, one and other for English
+var plurals = map[string]map[string][]string{
+	russian: {
+		"rouble":  {"рубль", "рубля", "рублей"},
+		"expense": {"расход", "расхода", "расходов"},
+	},
+	english: {
+		"rouble":  {"rouble", "roubles"},
+		"expense": {"expense", "expenses"},
+	},
+}
+
+// Localizer builds the reply texts in a language
+type Localizer struct {
+	Language string
+}
+
+// NewLocalizer creates new Localizer instant, unknown languages fall back to Russian
+func NewLocalizer(language string) *Localizer {
+	if _, ok := messages[language]; !ok {
+		language = russian
+	}
+	return &Localizer{Language: language}
+}
+
+// Text returns the message of the catalogue formatted with the arguments
+func (l *Localizer) Text(key string, args ...interface{}) string {
+	format, ok := messages[l.Language][key]
+	if !ok {
+		// Texts which are not in the catalogue, e.g. "ru|en", are the same in every language
+		if format, ok = messages[russian][key]; !ok {
+			format = key
+		}
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Plural returns the form of the word for the number, e.g. 1 рубль, 2 рубля, 5 рублей
+func (l *Localizer) Plural(n float64, word string) string {
+	forms := plurals[l.Language][word]
+	if l.Language != russian {
+		if n == 1 {
+			return forms[0]
+		}
+		return forms[1]
+	}
+	if n != math.Trunc(n) {
+		// Fractions take the genitive singular: 1,5 рубля
+		return forms[1]
+	}
+	i := int64(math.Abs(n))
+	switch {
+	case i%10 == 1 && i%100 != 11:
+		return forms[0]
+	case i%10 >= 2 && i%10 <= 4 && (i%100 < 12 || i%100 > 14):
+		return forms[1]
+	default:
+		return forms[2]
+	}
+}
+
+// Roubles formats the sheet value with the plural form, values which are not numbers are left as they are
+func (l *Localizer) Roubles(value string) string {
+	n, err := sheetNumber(value)
+	if err != nil {
+		return value
+	}
+	return value + " " + l.Plural(n, "rouble")
+}
+
+// userLanguage maps the language_code of a Telegram user to a language of the catalogue
+func userLanguage(user *tgbotapi.User) string {
+	if user == nil || user.LanguageCode == "" {
+		return ""
+	}
+	code := strings.ToLower(strings.SplitN(user.LanguageCode, "-", 2)[0])
+	if _, ok := messages[code]; ok {
+		return code
+	}
+	return ""
+}
+
+// localizer picks the language chosen in the chat, then the one of the user, then the default one
+func (tm *TableManagement) localizer(chatID int64, user *tgbotapi.User) *Localizer {
+	if language := tm.chats.Get(chatID).Language; language != "" {
+		return NewLocalizer(language)
+	}
+	if language := userLanguage(user); language != "" {
+		return NewLocalizer(language)
+	}
+	return NewLocalizer(tm.language)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Callback data prefixes of the confirmation keyboard buttons, the message ID follows the colon
+const (
+	confirmCallback = "confirm"
+	cancelCallback  = "cancel"
+)
+
+// Errors of taking a held message by its keyboard
+var (
+	errConfirmationExpired = errors.New("the confirmation is expired or unknown")
+	errForeignConfirmation = errors.New("the expense is held for another user")
+)
+
+// PendingExpenses holds messages with large amounts until they are confirmed, nothing is written before that
+type PendingExpenses struct {
+	mu        sync.Mutex
+	threshold float64
+	timeout   time.Duration
+	entries   map[string]*pendingExpense
+}
+
+type pendingExpense struct {
+	text    string
+	userID  int
+	expires time.Time
+}
+
+// NewPendingExpenses creates new PendingExpenses instant, zero threshold turns the confirmation off
+func NewPendingExpenses(threshold float64, timeout time.Duration) *PendingExpenses {
+	return &PendingExpenses{threshold: threshold, timeout: timeout, entries: make(map[string]*pendingExpense)}
+}
+
+// NeedsConfirmation reports whether any of the expenses is above the threshold
+func (pe *PendingExpenses) NeedsConfirmation(expenses []*Expense) bool {
+	if pe.threshold <= 0 {
+		return false
+	}
+	for _, expense := range expenses {
+		if expense.Sum >= pe.threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// Hold keeps the message text of the user until the confirmation and returns the keyboard to ask for it
+func (pe *PendingExpenses) Hold(l *Localizer, chatID int64, userID int, messageID int, text string, now time.Time) tgbotapi.InlineKeyboardMarkup {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	for key, entry := range pe.entries {
+		if now.After(entry.expires) {
+			delete(pe.entries, key)
+		}
+	}
+	pe.entries[pe.key(chatID, messageID)] = &pendingExpense{text: text, userID: userID, expires: now.Add(pe.timeout)}
+	id := strconv.Itoa(messageID)
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(l.Text("confirmButton"), confirmCallback+":"+id),
+		tgbotapi.NewInlineKeyboardButtonData(l.Text("cancelButton"), cancelCallback+":"+id),
+	))
+}
+
+// Take removes the held message of the callback data and returns its text. Only the user who sent
+// the message may take it, the message is kept when someone else presses the button.
+func (pe *PendingExpenses) Take(chatID int64, userID int, data string, now time.Time) (action string, text string, err error) {
+	parts := strings.SplitN(data, ":", 2)
+	if len(parts) != 2 {
+		return "", "", errConfirmationExpired
+	}
+	messageID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", "", errConfirmationExpired
+	}
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	key := pe.key(chatID, messageID)
+	entry, found := pe.entries[key]
+	if !found {
+		return parts[0], "", errConfirmationExpired
+	}
+	if entry.userID != userID {
+		return parts[0], "", errForeignConfirmation
+	}
+	delete(pe.entries, key)
+	if now.After(entry.expires) {
+		return parts[0], "", errConfirmationExpired
+	}
+	return parts[0], entry.text, nil
+}
+
+func (pe *PendingExpenses) key(chatID int64, messageID int) string {
+	return fmt.Sprintf("%d:%d", chatID, messageID)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Poller receives updates with long polling starting from the last confirmed offset.
+// Unlike BotAPI.GetUpdatesChan it does not acknowledge an update before it is confirmed
+// in the store, so updates which were not processed before a crash are delivered again.
+type Poller struct {
+	bot     *tgbotapi.BotAPI
+	store   *UpdateStore
+	timeout int
+	quit    chan struct{}
+}
+
+// NewPoller creates new Poller instant
+func NewPoller(bot *tgbotapi.BotAPI, store *UpdateStore, timeout int) *Poller {
+	return &Poller{bot: bot, store: store, timeout: timeout, quit: make(chan struct{})}
+}
+
+// Start begins polling and returns the channel with received updates
+func (p *Poller) Start() tgbotapi.UpdatesChannel {
+	// The next request is made only when the previous updates were confirmed, so it never
+	// receives them again and never acknowledges an update which is still being processed
+	ch := make(chan tgbotapi.Update)
+	go func() {
+		for {
+			select {
+			case <-p.quit:
+				return
+			default:
+			}
+			config := tgbotapi.NewUpdate(p.store.Offset())
+			config.Timeout = p.timeout
+			updates, err := p.bot.GetUpdates(config)
+			if err != nil {
+				log.Printf("Failed to get updates, retrying in 3 seconds: %v", err)
+				time.Sleep(3 * time.Second)
+				continue
+			}
+			for _, update := range updates {
+				select {
+				case ch <- update:
+				case <-p.quit:
+					return
+				}
+			}
+			if len(updates) > 0 && !p.waitConfirmed(updates[len(updates)-1].UpdateID) {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// waitConfirmed waits until the update is confirmed, false when polling is stopped first
+func (p *Poller) waitConfirmed(updateID int) bool {
+	for {
+		offset, confirmed := p.store.Confirmed()
+		if offset > updateID {
+			return true
+		}
+		select {
+		case <-confirmed:
+		case <-p.quit:
+			return false
+		}
+	}
+}
+
+// Stop stops polling, the request in progress is abandoned
+func (p *Poller) Stop() {
+	close(p.quit)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// quickAddMinCount is how many times an expense has to be typed to get a button
+const quickAddMinCount = 2
+
+// QuickAdd learns the most frequent expenses of every chat and offers them as a reply keyboard.
+// The keyboard is rebuilt once per refresh period so the buttons do not jump around every day.
+type QuickAdd struct {
+	path    string
+	size    int
+	refresh time.Duration
+	mu      sync.Mutex
+	chats   map[string]*quickAddChat
+}
+
+type quickAddChat struct {
+	Counts      map[string]float64 `json:"counts"`
+	Buttons     []string           `json:"buttons"`
+	RefreshedAt time.Time          `json:"refreshed_at"`
+}
+
+// NewQuickAdd creates new QuickAdd instant and loads the counts saved before.
+// Zero size turns the keyboard off, empty path keeps the counts in memory only.
+func NewQuickAdd(path string, size int, refresh time.Duration) (*QuickAdd, error) {
+	qa := &QuickAdd{path: path, size: size, refresh: refresh, chats: make(map[string]*quickAddChat)}
+	if path == "" || size <= 0 {
+		return qa, nil
+	}
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return qa, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bytes, &qa.chats); err != nil {
+		return nil, fmt.Errorf("could not parse quick add file %s: %v", path, err)
+	}
+	return qa, nil
+}
+
+// Learn counts the recorded expenses and returns the keyboard when it is time to refresh it,
+// the keyboard is removed when nothing is frequent anymore
+func (qa *QuickAdd) Learn(chatID int64, expenses []*Expense, now time.Time) (interface{}, bool) {
+	if qa.size <= 0 {
+		return nil, false
+	}
+	qa.mu.Lock()
+	defer qa.mu.Unlock()
+	key := strconv.FormatInt(chatID, 10)
+	chat, ok := qa.chats[key]
+	if !ok {
+		chat = &quickAddChat{Counts: make(map[string]float64), RefreshedAt: now}
+		qa.chats[key] = chat
+	}
+	for _, expense := range expenses {
+		if expense.Description == "" {
+			continue
+		}
+		chat.Counts[strings.ToLower(strings.Join(splitWords(expense.Text), " "))]++
+	}
+	refreshed, removed := false, false
+	if now.Sub(chat.RefreshedAt) >= qa.refresh || (len(chat.Buttons) == 0 && qa.frequent(chat) > 0) {
+		removed = len(chat.Buttons) > 0
+		chat.Buttons = qa.top(chat)
+		chat.RefreshedAt = now
+		refreshed = len(chat.Buttons) > 0
+		removed = removed && !refreshed
+		// Older habits fade out, half of the count is forgotten every refresh
+		for text, count := range chat.Counts {
+			if count/2 < 0.5 {
+				delete(chat.Counts, text)
+			} else {
+				chat.Counts[text] = count / 2
+			}
+		}
+	}
+	qa.save()
+	switch {
+	case refreshed:
+		return qa.keyboard(chat.Buttons), true
+	case removed:
+		return tgbotapi.NewRemoveKeyboard(false), true
+	default:
+		return nil, false
+	}
+}
+
+func (qa *QuickAdd) frequent(chat *quickAddChat) int {
+	frequent := 0
+	for _, count := range chat.Counts {
+		if count >= quickAddMinCount {
+			frequent++
+		}
+	}
+	return frequent
+}
+
+// top returns the texts of the most frequent expenses, the ties are sorted alphabetically
+func (qa *QuickAdd) top(chat *quickAddChat) []string {
+	var texts []string
+	for text, count := range chat.Counts {
+		if count >= quickAddMinCount {
+			texts = append(texts, text)
+		}
+	}
+	sort.Slice(texts, func(i, j int) bool {
+		if chat.Counts[texts[i]] != chat.Counts[texts[j]] {
+			return chat.Counts[texts[i]] > chat.Counts[texts[j]]
+		}
+		return texts[i] < texts[j]
+	})
+	if len(texts) > qa.size {
+		texts = texts[:qa.size]
+	}
+	return texts
+}
+
+// keyboard lays the buttons out two in a row
+func (qa *QuickAdd) keyboard(buttons []string) tgbotapi.ReplyKeyboardMarkup {
+	var rows [][]tgbotapi.KeyboardButton
+	for i := 0; i < len(buttons); i += 2 {
+		row := []tgbotapi.KeyboardButton{tgbotapi.NewKeyboardButton(buttons[i])}
+		if i+1 < len(buttons) {
+			row = append(row, tgbotapi.NewKeyboardButton(buttons[i+1]))
+		}
+		rows = append(rows, row)
+	}
+	return tgbotapi.NewReplyKeyboard(rows...)
+}
+
+func (qa *QuickAdd) save() {
+	if qa.path == "" {
+		return
+	}
+	bytes, err := json.Marshal(qa.chats)
+	if err == nil {
+		err = writeFileAtomically(qa.path, bytes)
+	}
+	if err != nil {
+		log.Printf("Could not save quick add file: %v", err)
+	}
+}
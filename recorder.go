@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// recordedSpreadsheetID replaces the real spreadsheet ID in recordings
+const recordedSpreadsheetID = "SPREADSHEET_ID"
+
+// recordedEvent is a line of a recording: an incoming update or a Sheets API exchange
+type recordedEvent struct {
+	Type     string            `json:"type"`
+	Time     string            `json:"time,omitempty"`
+	Update   *tgbotapi.Update  `json:"update,omitempty"`
+	Request  *recordedRequest  `json:"request,omitempty"`
+	Response *recordedResponse `json:"response,omitempty"`
+}
+
+type recordedRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Query  string `json:"query,omitempty"`
+	Body   string `json:"body,omitempty"`
+}
+
+type recordedResponse struct {
+	Status int    `json:"status"`
+	Body   string `json:"body"`
+}
+
+// Recorder writes incoming updates and Sheets API exchanges to a JSON lines file.
+// The secrets, e.g. tokens and the spreadsheet IDs, never get to the file.
+type Recorder struct {
+	mu             sync.Mutex
+	file           *os.File
+	spreadsheetIDs []string
+	secrets        []string
+}
+
+// NewRecorder creates new Recorder instant appending to the file
+func NewRecorder(path string, spreadsheetIDs []string, secrets ...string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	recorder := &Recorder{file: file, spreadsheetIDs: spreadsheetIDs}
+	for _, secret := range secrets {
+		if secret != "" {
+			recorder.secrets = append(recorder.secrets, secret)
+		}
+	}
+	return recorder, nil
+}
+
+// RecordUpdate writes the update together with the time it was processed at
+func (r *Recorder) RecordUpdate(update *tgbotapi.Update, at time.Time) {
+	r.write(&recordedEvent{Type: "update", Time: at.Format(time.RFC3339), Update: update})
+}
+
+// Transport wraps the base transport so every Sheets API exchange is recorded
+func (r *Recorder) Transport(base http.RoundTripper) http.RoundTripper {
+	return &recordingTransport{recorder: r, base: base}
+}
+
+// Close closes the file
+func (r *Recorder) Close() error {
+	return r.file.Close()
+}
+
+func (r *Recorder) write(event *recordedEvent) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Could not record %s: %v", event.Type, err)
+		return
+	}
+	line = []byte(r.scrub(string(line)))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		log.Printf("Could not record %s: %v", event.Type, err)
+	}
+}
+
+func (r *Recorder) scrub(text string) string {
+	for _, spreadsheetID := range r.spreadsheetIDs {
+		if spreadsheetID != "" {
+			text = strings.Replace(text, spreadsheetID, recordedSpreadsheetID, -1)
+		}
+	}
+	for _, secret := range r.secrets {
+		text = strings.Replace(text, secret, "<scrubbed>", -1)
+	}
+	return text
+}
+
+type recordingTransport struct {
+	recorder *Recorder
+	base     http.RoundTripper
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	request := &recordedRequest{Method: req.Method, Path: req.URL.Path, Query: req.URL.RawQuery}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		request.Body = string(body)
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	resp, err := rt.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	rt.recorder.write(&recordedEvent{
+		Type:     "sheets",
+		Request:  request,
+		Response: &recordedResponse{Status: resp.StatusCode, Body: string(body)},
+	})
+	return resp, nil
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RecurringExpense is an expense written every month on its day, e.g. the rent on the 5th
+type RecurringExpense struct {
+	ID          int     `json:"id"`
+	Day         int     `json:"day"`
+	Sum         float64 `json:"sum"`
+	Description string  `json:"description"`
+	// Written is the last month the expense was written in or was not due in yet, e.g. "2026-03"
+	Written string `json:"written,omitempty"`
+}
+
+// RecurringExpenses keeps the recurring expenses of every chat in a file, empty path keeps them in memory only.
+// An expense is written once per month: the month is saved before the write, so the scheduler may run
+// as often as it likes and a crash during the write never writes it twice. After a downtime the missed
+// months are written as well.
+type RecurringExpenses struct {
+	path  string
+	mu    sync.Mutex
+	chats map[string]*recurringChat
+}
+
+type recurringChat struct {
+	NextID   int                 `json:"next_id"`
+	Expenses []*RecurringExpense `json:"expenses"`
+}
+
+// NewRecurringExpenses creates new RecurringExpenses instant and loads the expenses saved before
+func NewRecurringExpenses(path string) (*RecurringExpenses, error) {
+	re := &RecurringExpenses{path: path, chats: make(map[string]*recurringChat)}
+	if path == "" {
+		return re, nil
+	}
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return re, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bytes, &re.chats); err != nil {
+		return nil, fmt.Errorf("could not parse recurring expenses %s: %v", path, err)
+	}
+	return re, nil
+}
+
+// Add saves the expense of the chat and returns its ID. An expense whose day has already passed
+// this month starts from the next one.
+func (re *RecurringExpenses) Add(chatID int64, day int, sum float64, description string, today time.Time) int {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	key := strconv.FormatInt(chatID, 10)
+	chat, ok := re.chats[key]
+	if !ok {
+		chat = &recurringChat{}
+		re.chats[key] = chat
+	}
+	chat.NextID++
+	expense := &RecurringExpense{ID: chat.NextID, Day: day, Sum: sum, Description: description, Written: monthOf(today)}
+	if dueDay(today, day) >= today.Day() {
+		expense.Written = monthOf(time.Date(today.Year(), today.Month()-1, 1, 0, 0, 0, 0, today.Location()))
+	}
+	chat.Expenses = append(chat.Expenses, expense)
+	re.save()
+	return expense.ID
+}
+
+// List returns copies of the expenses of the chat ordered by day
+func (re *RecurringExpenses) List(chatID int64) []RecurringExpense {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	var expenses []RecurringExpense
+	if chat, ok := re.chats[strconv.FormatInt(chatID, 10)]; ok {
+		for _, expense := range chat.Expenses {
+			expenses = append(expenses, *expense)
+		}
+	}
+	sort.SliceStable(expenses, func(i, j int) bool { return expenses[i].Day < expenses[j].Day })
+	return expenses
+}
+
+// Remove deletes the expense of the chat, ok is false when there is no such expense
+func (re *RecurringExpenses) Remove(chatID int64, id int) bool {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	chat, ok := re.chats[strconv.FormatInt(chatID, 10)]
+	if !ok {
+		return false
+	}
+	for i, expense := range chat.Expenses {
+		if expense.ID == id {
+			chat.Expenses = append(chat.Expenses[:i], chat.Expenses[i+1:]...)
+			re.save()
+			return true
+		}
+	}
+	return false
+}
+
+// Chats returns the chats having recurring expenses
+func (re *RecurringExpenses) Chats() []int64 {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	var chatIDs []int64
+	for key, chat := range re.chats {
+		if len(chat.Expenses) == 0 {
+			continue
+		}
+		if chatID, err := strconv.ParseInt(key, 10, 64); err == nil {
+			chatIDs = append(chatIDs, chatID)
+		}
+	}
+	sort.Slice(chatIDs, func(i, j int) bool { return chatIDs[i] < chatIDs[j] })
+	return chatIDs
+}
+
+// dueExpense is a recurring expense to write for a month
+type dueExpense struct {
+	id      int
+	month   string
+	expense *Expense
+}
+
+// Due returns the due expenses of the chat dated by their days, including the months missed since the last write
+func (re *RecurringExpenses) Due(chatID int64, today time.Time) []dueExpense {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	chat, ok := re.chats[strconv.FormatInt(chatID, 10)]
+	if !ok {
+		return nil
+	}
+	var due []dueExpense
+	current := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+	for _, recurring := range chat.Expenses {
+		month := current
+		if written, err := time.ParseInLocation("2006-01", recurring.Written, today.Location()); err == nil {
+			month = written.AddDate(0, 1, 0)
+		}
+		for ; !month.After(current); month = month.AddDate(0, 1, 0) {
+			day := dueDay(month, recurring.Day)
+			if month.Equal(current) && day > today.Day() {
+				break
+			}
+			due = append(due, dueExpense{id: recurring.ID, month: monthOf(month), expense: &Expense{
+				Date:        time.Date(month.Year(), month.Month(), day, 0, 0, 0, 0, today.Location()),
+				Description: recurring.Description,
+				Sum:         recurring.Sum,
+				Text:        recurring.Description + " " + strconv.FormatFloat(recurring.Sum, 'f', -1, 64),
+			}})
+		}
+	}
+	return due
+}
+
+// MarkWritten saves that the expenses are written up to their months
+func (re *RecurringExpenses) MarkWritten(chatID int64, due []dueExpense) {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	chat, ok := re.chats[strconv.FormatInt(chatID, 10)]
+	if !ok {
+		return
+	}
+	for _, recurring := range chat.Expenses {
+		for _, entry := range due {
+			if entry.id == recurring.ID && entry.month > recurring.Written {
+				recurring.Written = entry.month
+			}
+		}
+	}
+	re.save()
+}
+
+func (re *RecurringExpenses) save() {
+	if re.path == "" {
+		return
+	}
+	bytes, err := json.Marshal(re.chats)
+	if err == nil {
+		err = writeFileAtomically(re.path, bytes)
+	}
+	if err != nil {
+		log.Printf("Could not save recurring expenses: %v", err)
+	}
+}
+
+// dueDay moves the days the month does not have to its last day, e.g. the 31st is the 30th in April
+func dueDay(today time.Time, day int) int {
+	last := time.Date(today.Year(), today.Month()+1, 0, 0, 0, 0, 0, today.Location()).Day()
+	if day > last {
+		return last
+	}
+	return day
+}
+
+func monthOf(date time.Time) string {
+	return date.Format("2006-01")
+}
+
+// writeRecurring writes the due recurring expenses of the chat and returns the notification for it,
+// ok is false when nothing was due. The expenses are written per spreadsheet, and every batch is marked
+// written right before its write, so a failed batch is not retried but reported to the chat to be entered by hand.
+func (tm *TableManagement) writeRecurring(chatID int64) (string, bool) {
+	tm = tm.forChat(chatID)
+	due := tm.recurring.Due(chatID, tm.today())
+	if len(due) == 0 {
+		return "", false
+	}
+	var years []int
+	byYear := make(map[int][]dueExpense)
+	for _, entry := range due {
+		year := entry.expense.Date.Year()
+		if _, ok := byYear[year]; !ok {
+			years = append(years, year)
+		}
+		byYear[year] = append(byYear[year], entry)
+	}
+	sort.Ints(years)
+	var written, failed []*Expense
+	for _, year := range years {
+		var expenses []*Expense
+		for _, entry := range byYear[year] {
+			expenses = append(expenses, entry.expense)
+		}
+		tm.recurring.MarkWritten(chatID, byYear[year])
+		if err := tm.writeByYear(expenses); err != nil {
+			log.Printf("Could not write recurring expenses of %d of the chat %d: %v", year, chatID, err)
+			failed = append(failed, expenses...)
+			continue
+		}
+		written = append(written, expenses...)
+	}
+	l := tm.localizer(chatID, nil)
+	var lines []string
+	if len(written) > 0 {
+		lines = append(lines, l.Text("recurringWritten", len(written), l.Plural(float64(len(written)), "expense")), tm.formatExpenses(written))
+		if balance, err := tm.GetTableBalance("db"); err == nil {
+			lines = append(lines, l.Text("dailyBalance", l.Roubles(balance)))
+		}
+	}
+	if len(failed) > 0 {
+		lines = append(lines, l.Text("recurringFailed"), tm.formatExpenses(failed))
+	}
+	replyText := strings.Join(lines, "\n")
+	log.Printf("Recurring expenses of the chat %d: %s", chatID, replyText)
+	return replyText, true
+}
+
+// recurringMaxArgs bounds the words of "/recurring add 5 30000 аренда квартиры"
+const recurringMaxArgs = 32
+
+// recurringCommand handles "/recurring add <day> <amount> <description>", "/recurring list" and "/recurring remove <id>"
+func recurringCommand(tm *TableManagement, request *CommandRequest) (string, error) {
+	l := request.Localizer
+	usage := l.Text("usage", tm.commands.usage(l, tm.commands.byName["recurring"]))
+	args := request.Args[1:]
+	switch strings.ToLower(request.Args[0]) {
+	case "add", "добавить":
+		if len(args) < 2 {
+			return usage, nil
+		}
+		day, err := strconv.Atoi(args[0])
+		if err != nil || day < 1 || day > 31 {
+			return l.Text("invalidMonthDay", args[0]), nil
+		}
+		description, sum, err := tm.parseInput(strings.Join(args[1:], " "))
+		if err != nil {
+			return "", err
+		}
+		if sum <= 0 {
+			return usage, nil
+		}
+		id := tm.recurring.Add(request.ChatID, day, sum, description, tm.today())
+		return l.Text("recurringAdded", id, formatRecurring(l, RecurringExpense{Day: day, Sum: sum, Description: description})), nil
+	case "list", "список":
+		if len(args) != 0 {
+			return usage, nil
+		}
+		var lines []string
+		for _, expense := range tm.recurring.List(request.ChatID) {
+			lines = append(lines, fmt.Sprintf("%d. %s", expense.ID, formatRecurring(l, expense)))
+		}
+		if len(lines) == 0 {
+			return l.Text("recurringEmpty"), nil
+		}
+		return strings.Join(lines, "\n"), nil
+	case "remove", "удалить":
+		if len(args) != 1 {
+			return usage, nil
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+		if err != nil || !tm.recurring.Remove(request.ChatID, id) {
+			return l.Text("unknownRecurring", args[0]), nil
+		}
+		return l.Text("recurringRemoved", id), nil
+	default:
+		return usage, nil
+	}
+}
+
+func formatRecurring(l *Localizer, expense RecurringExpense) string {
+	line := strconv.FormatFloat(expense.Sum, 'f', -1, 64)
+	if expense.Description != "" {
+		line = expense.Description + " — " + line
+	}
+	return l.Text("recurringEntry", expense.Day, line)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDue(t *testing.T) {
+	re, _ := NewRecurringExpenses("")
+	re.Add(1, 31, 30000, "аренда", time.Date(2026, time.January, 10, 0, 0, 0, 0, time.UTC))
+
+	// The bot was down from January till the 5th of April
+	due := re.Due(1, time.Date(2026, time.April, 5, 0, 0, 0, 0, time.UTC))
+	var dates []string
+	for _, entry := range due {
+		dates = append(dates, entry.expense.Date.Format("2006-01-02"))
+	}
+	want := []string{"2026-01-31", "2026-02-28", "2026-03-31"}
+	if len(dates) != len(want) {
+		t.Fatalf("Due() dates = %v, want %v", dates, want)
+	}
+	for i := range want {
+		if dates[i] != want[i] {
+			t.Fatalf("Due() dates = %v, want %v", dates, want)
+		}
+	}
+	if again := re.Due(1, time.Date(2026, time.April, 5, 0, 0, 0, 0, time.UTC)); len(again) != len(want) {
+		t.Errorf("Due() before MarkWritten = %d expenses, want %d", len(again), len(want))
+	}
+	re.MarkWritten(1, due)
+	if again := re.Due(1, time.Date(2026, time.April, 5, 0, 0, 0, 0, time.UTC)); len(again) != 0 {
+		t.Errorf("Due() after MarkWritten = %d expenses, want none", len(again))
+	}
+	if april := re.Due(1, time.Date(2026, time.April, 30, 0, 0, 0, 0, time.UTC)); len(april) != 1 {
+		t.Errorf("Due() on the last day of April = %d expenses, want 1", len(april))
+	}
+}
+
+func TestWriteRecurringListsOnlyFailedYear(t *testing.T) {
+	tm, server := newSheetsManagement(t)
+	defer server.Close()
+	// Only 2026 has a spreadsheet, so December 2025 can not be written
+	tm.spreadsheets = map[int]string{2026: "test"}
+	tm.recurring.Add(1, 14, 100, "подписка", time.Date(2025, time.November, 20, 0, 0, 0, 0, time.UTC))
+
+	replyText, ok := tm.writeRecurring(1)
+	if !ok {
+		t.Fatal("writeRecurring() = false, want the due expenses")
+	}
+	written, failed := replyText, ""
+	if i := strings.Index(replyText, "Не удалось"); i >= 0 {
+		written, failed = replyText[:i], replyText[i:]
+	}
+	if strings.Contains(written, "14.12") || !strings.Contains(failed, "14.12") {
+		t.Errorf("December 2025 is not reported as failed:\n%s", replyText)
+	}
+	for _, date := range []string{"14.01", "14.02", "14.03"} {
+		if !strings.Contains(written, date) || strings.Contains(failed, date) {
+			t.Errorf("%s is not reported as written:\n%s", date, replyText)
+		}
+	}
+	if value := server.Cell("Март!I15"); value != 100.0 {
+		t.Errorf("March cell = %#v, want 100", value)
+	}
+	if _, again := tm.writeRecurring(1); again {
+		t.Error("writeRecurring() again wrote the expenses twice")
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"google.golang.org/api/sheets/v4"
+)
+
+// Replayer feeds a recording through the bot and builds a transcript
+// of the replies and sheet writes which can be compared with a golden file
+type Replayer struct {
+	events     []*recordedEvent
+	mu         sync.Mutex
+	responses  map[string][]*recordedResponse
+	transcript []string
+}
+
+// NewReplayer creates new Replayer instant from a recording made with RECORD_FILE
+func NewReplayer(path string) (*Replayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rp := &Replayer{responses: make(map[string][]*recordedResponse)}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		event := &recordedEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), event); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		switch event.Type {
+		case "update":
+			rp.events = append(rp.events, event)
+		case "sheets":
+			key := rp.key(event.Request.Method, event.Request.Path, event.Request.Query)
+			rp.responses[key] = append(rp.responses[key], event.Response)
+		}
+	}
+	return rp, scanner.Err()
+}
+
+// Run processes every recorded update with the properties of the configuration and returns the transcript.
+// The state files of the configuration are left alone, the replay starts from empty chat settings, debts,
+// goals and the rest. The clock is the recorded time of every update, and without TIMEZONE the location
+// is the recorded offset, so the transcript does not depend on the machine it is replayed on.
+func (rp *Replayer) Run(config *Configuration) ([]string, error) {
+	ts, err := NewTableService(&ConnectionProperties{
+		SpreadsheetID: recordedSpreadsheetID,
+		Endpoint:      "http://replay.invalid/",
+		WrapTransport: func(http.RoundTripper) http.RoundTripper { return rp },
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	spreadsheets, err := config.Spreadsheets()
+	if err != nil {
+		return nil, err
+	}
+	// The recording has the spreadsheet IDs scrubbed
+	for year := range spreadsheets {
+		spreadsheets[year] = recordedSpreadsheetID
+	}
+	replayConfig := *config
+	replayConfig.ChatSettingsFile, replayConfig.QuickAddFile, replayConfig.RecurringFile = "", "", ""
+	replayConfig.LedgerFile, replayConfig.AuthorTotalsFile, replayConfig.GoalsFile = "", "", ""
+	properties, err := newManagementProperties(&replayConfig, spreadsheets)
+	if err != nil {
+		return nil, err
+	}
+	tm := NewTableManagement(ts, properties)
+	location := config.Timezone
+	if location == "" {
+		location = "recorded"
+	}
+	rp.log("location %s, day ends at %d", location, config.DayEndsAt)
+	for _, event := range rp.events {
+		at, err := time.Parse(time.RFC3339, event.Time)
+		if err != nil {
+			return nil, fmt.Errorf("update %d has invalid time: %v", event.Update.UpdateID, err)
+		}
+		if config.Timezone == "" {
+			tm.location = time.FixedZone(at.Zone())
+			at = at.In(tm.location)
+		}
+		tm.now = func() time.Time { return at }
+		rp.log("at %s", at.In(tm.location).Format(time.RFC3339))
+		update := event.Update
+		if update.CallbackQuery != nil && update.CallbackQuery.Message != nil {
+			rp.log("update %d chat %d: button %q", update.UpdateID, update.CallbackQuery.Message.Chat.ID, update.CallbackQuery.Data)
+			answer, edit := processCallback(tm, update.CallbackQuery)
+			if edit.Text == "" {
+				rp.log("answer %q", answer.Text)
+				continue
+			}
+			rp.log("edit chat %d: %q", edit.ChatID, edit.Text)
+			continue
+		}
+		if update.InlineQuery != nil {
+			rp.log("update %d inline query of %d: %q", update.UpdateID, userID(update.InlineQuery.From), update.InlineQuery.Query)
+			for _, result := range processInlineQuery(tm, update.InlineQuery).Results {
+				article := result.(tgbotapi.InlineQueryResultArticle)
+				rp.log("inline result %s: %q", article.ID, article.Title)
+			}
+			continue
+		}
+		if update.ChosenInlineResult != nil {
+			rp.log("update %d inline result %s chosen by %d: %q", update.UpdateID, update.ChosenInlineResult.ResultID, userID(update.ChosenInlineResult.From), update.ChosenInlineResult.Query)
+			processChosenInlineResult(tm, update.ChosenInlineResult)
+			continue
+		}
+		if update.Message == nil {
+			rp.log("update %d without message", update.UpdateID)
+			continue
+		}
+		rp.log("update %d chat %d: %q", update.UpdateID, update.Message.Chat.ID, update.Message.Text)
+		replyMessage := reply(tm, update)
+		rp.log("reply chat %d: %q", replyMessage.ChatID, replyMessage.Text)
+	}
+	return rp.transcript, nil
+}
+
+// RoundTrip answers Sheets API requests with the recorded responses and logs the writes
+func (rp *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	switch req.Method {
+	case http.MethodPut:
+		var valueRange sheets.ValueRange
+		json.Unmarshal(body, &valueRange)
+		rp.logWrite(valuesRangeFromPath(req.URL.Path), valueRange.Values)
+	case http.MethodPost:
+		var request sheets.BatchUpdateValuesRequest
+		json.Unmarshal(body, &request)
+		for _, valueRange := range request.Data {
+			rp.logWrite(valueRange.Range, valueRange.Values)
+		}
+	}
+	response := rp.response(req.Method, req.URL.Path, req.URL.RawQuery)
+	if response == nil {
+		rp.log("not recorded %s %s", req.Method, valuesRangeFromPath(req.URL.Path))
+		response = &recordedResponse{Status: http.StatusOK, Body: "{}"}
+	}
+	return &http.Response{
+		StatusCode: response.Status,
+		Status:     http.StatusText(response.Status),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(response.Body)),
+		Request:    req,
+	}, nil
+}
+
+// response returns the next recorded response for the request, the last one is reused when they run out
+func (rp *Replayer) response(method string, path string, query string) *recordedResponse {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	key := rp.key(method, path, query)
+	responses := rp.responses[key]
+	if len(responses) == 0 {
+		return nil
+	}
+	if len(responses) > 1 {
+		rp.responses[key] = responses[1:]
+	}
+	return responses[0]
+}
+
+func (rp *Replayer) key(method string, path string, query string) string {
+	return method + " " + path + "?" + query
+}
+
+func (rp *Replayer) logWrite(workingRange string, values [][]interface{}) {
+	encoded, _ := json.Marshal(values)
+	rp.log("write %s %s", workingRange, encoded)
+}
+
+func (rp *Replayer) log(format string, args ...interface{}) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.transcript = append(rp.transcript, fmt.Sprintf(format, args...))
+}
+
+func valuesRangeFromPath(path string) string {
+	if i := strings.Index(path, "/values/"); i != -1 {
+		return path[i+len("/values/"):]
+	}
+	return path
+}
+
+// runReplay replays the recording with the configuration of the bot and compares the transcript with the golden file
+func runReplay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	update := flags.Bool("update", false, "write the transcript to the golden file instead of comparing")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: tinkoff-table-bot replay [-update] [flags] recording.jsonl golden.txt")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	config, err := LoadConfiguration(flags.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(config.Args()) != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	recording, goldenFile := config.Args()[0], config.Args()[1]
+	replayer, err := NewReplayer(recording)
+	if err != nil {
+		log.Fatalf("Could not load recording: %v", err)
+	}
+	transcript, err := replayer.Run(config)
+	if err != nil {
+		log.Fatalf("Could not replay recording: %v", err)
+	}
+	actual := strings.Join(transcript, "\n") + "\n"
+	if *update {
+		if err := ioutil.WriteFile(goldenFile, []byte(actual), 0644); err != nil {
+			log.Fatalf("Could not write golden file: %v", err)
+		}
+		return
+	}
+	golden, err := ioutil.ReadFile(goldenFile)
+	if err != nil {
+		log.Fatalf("Could not read golden file: %v", err)
+	}
+	if string(golden) == actual {
+		fmt.Println("Transcript matches the golden file")
+		return
+	}
+	fmt.Print(diffLines(strings.Split(strings.TrimSuffix(string(golden), "\n"), "\n"), transcript))
+	os.Exit(1)
+}
+
+// diffLines returns a line diff of the expected and actual texts, unchanged lines start with a space
+func diffLines(expected []string, actual []string) string {
+	// lengths[i][j] is the longest common subsequence of expected[i:] and actual[j:]
+	lengths := make([][]int, len(expected)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(actual)+1)
+	}
+	for i := len(expected) - 1; i >= 0; i-- {
+		for j := len(actual) - 1; j >= 0; j-- {
+			if expected[i] == actual[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	var diff strings.Builder
+	i, j := 0, 0
+	for i < len(expected) || j < len(actual) {
+		switch {
+		case i < len(expected) && j < len(actual) && expected[i] == actual[j]:
+			fmt.Fprintf(&diff, "  %s\n", expected[i])
+			i++
+			j++
+		case i < len(expected) && (j == len(actual) || lengths[i+1][j] >= lengths[i][j+1]):
+			fmt.Fprintf(&diff, "- %s\n", expected[i])
+			i++
+		default:
+			fmt.Fprintf(&diff, "+ %s\n", actual[j])
+			j++
+		}
+	}
+	return diff.String()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "write the replay transcript to the golden file")
+
+// TestReplay replays a recording of a bot running in Moscow, the expense after midnight goes to the next day
+// whatever the timezone of the machine is
+func TestReplay(t *testing.T) {
+	replayer, err := NewReplayer("testdata/replay.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Configuration{}
+	if err := config.applyDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	transcript, err := replayer.Run(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := strings.Join(transcript, "\n") + "\n"
+	if *updateGolden {
+		if err := ioutil.WriteFile("testdata/replay.golden", []byte(actual), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	golden, err := ioutil.ReadFile("testdata/replay.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(golden) != actual {
+		t.Errorf("transcript differs from the golden file:\n%s",
+			diffLines(strings.Split(strings.TrimSuffix(string(golden), "\n"), "\n"), transcript))
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// goalPattern matches `"Отпуск" 150000 by 2027-06`, the name may be quoted with «» or not quoted at all
+var goalPattern = regexp.MustCompile(`^(?:["“”]([^"“”]+)["“”]|«([^»]+)»|(.+?))\s+(\S+)\s+(?:by|до)\s+(\d{4})-(\d{1,2})$`)
+
+// SavingsGoal is a sum to save by a month, the savings are the accumulation cells of the months since it was set
+type SavingsGoal struct {
+	ID     int     `json:"id"`
+	Name   string  `json:"name"`
+	Target float64 `json:"target"`
+	// Start and Deadline are the first and the last months of the goal, e.g. "2026-10" and "2027-06"
+	Start    string `json:"start"`
+	Deadline string `json:"deadline"`
+	// Checked is the last month the pace of the goal was checked in
+	Checked string `json:"checked,omitempty"`
+}
+
+// GoalProgress is how far a goal is
+type GoalProgress struct {
+	Saved float64
+	// Months is the number of the months the savings are taken from
+	Months int
+	// Needed is the monthly saving to reach the goal by the deadline, zero when it is reached or overdue
+	Needed float64
+	// Estimate is the month the goal is reached at the average pace, zero when the pace is unknown
+	Estimate time.Time
+}
+
+// SavingsGoals keeps the savings goals of every chat in a file, empty path keeps them in memory only
+type SavingsGoals struct {
+	path  string
+	mu    sync.Mutex
+	chats map[string]*goalsChat
+}
+
+type goalsChat struct {
+	NextID int            `json:"next_id"`
+	Goals  []*SavingsGoal `json:"goals"`
+}
+
+// NewSavingsGoals creates new SavingsGoals instant and loads the goals saved before
+func NewSavingsGoals(path string) (*SavingsGoals, error) {
+	sg := &SavingsGoals{path: path, chats: make(map[string]*goalsChat)}
+	if path == "" {
+		return sg, nil
+	}
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return sg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bytes, &sg.chats); err != nil {
+		return nil, fmt.Errorf("could not parse savings goals %s: %v", path, err)
+	}
+	return sg, nil
+}
+
+// Add saves the goal of the chat and returns its ID
+func (sg *SavingsGoals) Add(chatID int64, goal SavingsGoal) int {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	key := strconv.FormatInt(chatID, 10)
+	chat, ok := sg.chats[key]
+	if !ok {
+		chat = &goalsChat{}
+		sg.chats[key] = chat
+	}
+	chat.NextID++
+	goal.ID = chat.NextID
+	chat.Goals = append(chat.Goals, &goal)
+	sg.save()
+	return goal.ID
+}
+
+// List returns copies of the goals of the chat
+func (sg *SavingsGoals) List(chatID int64) []SavingsGoal {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	var goals []SavingsGoal
+	if chat, ok := sg.chats[strconv.FormatInt(chatID, 10)]; ok {
+		for _, goal := range chat.Goals {
+			goals = append(goals, *goal)
+		}
+	}
+	return goals
+}
+
+// Remove deletes the goal of the chat, ok is false when there is no such goal
+func (sg *SavingsGoals) Remove(chatID int64, id int) bool {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	chat, ok := sg.chats[strconv.FormatInt(chatID, 10)]
+	if !ok {
+		return false
+	}
+	for i, goal := range chat.Goals {
+		if goal.ID == id {
+			chat.Goals = append(chat.Goals[:i], chat.Goals[i+1:]...)
+			sg.save()
+			return true
+		}
+	}
+	return false
+}
+
+// Chats returns the chats having goals
+func (sg *SavingsGoals) Chats() []int64 {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	var chatIDs []int64
+	for key, chat := range sg.chats {
+		if len(chat.Goals) == 0 {
+			continue
+		}
+		if chatID, err := strconv.ParseInt(key, 10, 64); err == nil {
+			chatIDs = append(chatIDs, chatID)
+		}
+	}
+	return chatIDs
+}
+
+// Unchecked returns copies of the goals of the chat whose pace was not checked this month
+func (sg *SavingsGoals) Unchecked(chatID int64, today time.Time) []SavingsGoal {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	chat, ok := sg.chats[strconv.FormatInt(chatID, 10)]
+	if !ok {
+		return nil
+	}
+	var goals []SavingsGoal
+	for _, goal := range chat.Goals {
+		if goal.Checked != monthOf(today) {
+			goals = append(goals, *goal)
+		}
+	}
+	return goals
+}
+
+// MarkChecked saves that the pace of the goals was checked this month
+func (sg *SavingsGoals) MarkChecked(chatID int64, ids []int, today time.Time) {
+	if len(ids) == 0 {
+		return
+	}
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	chat, ok := sg.chats[strconv.FormatInt(chatID, 10)]
+	if !ok {
+		return
+	}
+	checked := make(map[int]bool)
+	for _, id := range ids {
+		checked[id] = true
+	}
+	for _, goal := range chat.Goals {
+		if checked[goal.ID] {
+			goal.Checked = monthOf(today)
+		}
+	}
+	sg.save()
+}
+
+func (sg *SavingsGoals) save() {
+	if sg.path == "" {
+		return
+	}
+	bytes, err := json.Marshal(sg.chats)
+	if err == nil {
+		err = writeFileAtomically(sg.path, bytes)
+	}
+	if err != nil {
+		log.Printf("Could not save savings goals: %v", err)
+	}
+}
+
+// parseGoal parses `"Отпуск" 150000 by 2027-06`, the deadline must not be in the past
+func (tm *TableManagement) parseGoal(text string, today time.Time) (SavingsGoal, bool) {
+	match := goalPattern.FindStringSubmatch(strings.TrimSpace(text))
+	if match == nil {
+		return SavingsGoal{}, false
+	}
+	name := strings.TrimSpace(match[1] + match[2] + match[3])
+	description, target, err := tm.parseInput(match[4])
+	if err != nil || description != "" || target <= 0 || name == "" {
+		return SavingsGoal{}, false
+	}
+	year, _ := strconv.Atoi(match[5])
+	month, _ := strconv.Atoi(match[6])
+	if month < 1 || month > 12 {
+		return SavingsGoal{}, false
+	}
+	deadline := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, today.Location())
+	if monthsBetween(today, deadline) < 0 {
+		return SavingsGoal{}, false
+	}
+	return SavingsGoal{Name: name, Target: target, Start: monthOf(today), Deadline: monthOf(deadline)}, true
+}
+
+// goalProgress reads the accumulation of every month from the start of the goal through the last one
+func (tm *TableManagement) goalProgress(goal SavingsGoal, last time.Time) (GoalProgress, error) {
+	progress := GoalProgress{}
+	last = time.Date(last.Year(), last.Month(), 1, 0, 0, 0, 0, last.Location())
+	start, err := time.ParseInLocation("2006-01", goal.Start, last.Location())
+	if err != nil {
+		return progress, err
+	}
+	// One batch per spreadsheet, the months of a year are the sheets of its spreadsheet
+	ranges := make(map[int][]string)
+	var years []int
+	for month := start; monthsBetween(month, last) >= 0; month = month.AddDate(0, 1, 0) {
+		if _, ok := ranges[month.Year()]; !ok {
+			years = append(years, month.Year())
+		}
+		ranges[month.Year()] = append(ranges[month.Year()], fmt.Sprintf("%s!D21", sheetName(month.Month())))
+	}
+	for _, year := range years {
+		ts, err := tm.tableFor(year)
+		if err != nil {
+			return progress, err
+		}
+		receivedRanges, err := ts.BatchGetData(ranges[year])
+		if err != nil {
+			return progress, err
+		}
+		for _, receivedRange := range receivedRanges {
+			if len(receivedRange.Values) > 0 && len(receivedRange.Values[0]) > 0 {
+				if saved, err := sheetNumber(receivedRange.Values[0][0]); err == nil {
+					progress.Saved += saved
+				}
+			}
+		}
+		progress.Months += len(ranges[year])
+	}
+	progress.Saved = math.Round(progress.Saved*100) / 100
+	remaining := goal.Target - progress.Saved
+	if remaining <= 0 {
+		return progress, nil
+	}
+	deadline, err := time.ParseInLocation("2006-01", goal.Deadline, last.Location())
+	if err != nil {
+		return progress, err
+	}
+	// The months left to save in, the current and the deadline ones included
+	if left := monthsBetween(tm.today(), deadline) + 1; left > 0 {
+		progress.Needed = math.Ceil(remaining / float64(left))
+	}
+	if progress.Months > 0 && progress.Saved > 0 {
+		pace := progress.Saved / float64(progress.Months)
+		progress.Estimate = last.AddDate(0, int(math.Ceil(remaining/pace)), 0)
+	}
+	return progress, nil
+}
+
+// formatGoal describes the goal and its progress for the reply
+func formatGoal(l *Localizer, goal SavingsGoal, progress GoalProgress) string {
+	percent := int(math.Min(100, math.Floor(progress.Saved/goal.Target*100)))
+	line := l.Text("goalProgress", goal.ID, goal.Name, formatSum(progress.Saved), l.Roubles(formatSum(goal.Target)), percent)
+	switch {
+	case progress.Saved >= goal.Target:
+		return line + ", " + l.Text("goalReached")
+	case progress.Needed > 0:
+		line += ", " + l.Text("goalNeeded", l.Roubles(formatSum(progress.Needed)), goal.Deadline)
+	default:
+		line += ", " + l.Text("goalOverdue", goal.Deadline)
+	}
+	if progress.Estimate.IsZero() {
+		return line + ", " + l.Text("goalNoEstimate")
+	}
+	return line + ", " + l.Text("goalEstimate", monthOf(progress.Estimate))
+}
+
+// remindGoals sends the reminders of the goals of the chat which fall behind the pace they need,
+// every goal is checked once a month against the months finished before. A goal stays unchecked
+// when its progress could not be read or the reminder could not be sent, so the next run retries it.
+func (tm *TableManagement) remindGoals(chatID int64, send func(text string) error) {
+	tm = tm.forChat(chatID)
+	today := tm.today()
+	lastMonth := time.Date(today.Year(), today.Month()-1, 1, 0, 0, 0, 0, today.Location())
+	l := tm.localizer(chatID, nil)
+	var checked, behind []int
+	var reminders []string
+	for _, goal := range tm.goals.Unchecked(chatID, today) {
+		if goal.Start > monthOf(lastMonth) {
+			continue
+		}
+		progress, err := tm.goalProgress(goal, lastMonth)
+		if err != nil {
+			log.Printf("Could not check the goal %q of the chat %d: %v", goal.Name, chatID, err)
+			continue
+		}
+		pace := progress.Saved / float64(progress.Months)
+		if progress.Needed <= 0 || pace >= progress.Needed {
+			checked = append(checked, goal.ID)
+			continue
+		}
+		behind = append(behind, goal.ID)
+		reminders = append(reminders, l.Text("goalBehind", goal.Name, l.Roubles(formatSum(math.Max(0, math.Floor(pace)))),
+			l.Roubles(formatSum(progress.Needed)), goal.Deadline))
+	}
+	if len(reminders) > 0 {
+		if err := send(strings.Join(reminders, "\n")); err != nil {
+			log.Printf("Could not remind of the goals of the chat %d: %v", chatID, err)
+		} else {
+			checked = append(checked, behind...)
+		}
+	}
+	tm.goals.MarkChecked(chatID, checked, today)
+}
+
+// goalMaxArgs bounds the words of `/goal add "Отпуск на море" 150000 by 2027-06`
+const goalMaxArgs = 32
+
+// goalCommand handles `/goal add "Отпуск" 150000 by 2027-06`, "/goal" with the progress and "/goal remove <id>"
+func goalCommand(tm *TableManagement, request *CommandRequest) (string, error) {
+	l := request.Localizer
+	usage := l.Text("usage", tm.commands.usage(l, tm.commands.byName["goal"]))
+	subcommand := "list"
+	if len(request.Args) > 0 {
+		subcommand = strings.ToLower(request.Args[0])
+	}
+	switch subcommand {
+	case "add", "добавить":
+		goal, ok := tm.parseGoal(strings.Join(request.Args[1:], " "), tm.today())
+		if !ok {
+			return usage, nil
+		}
+		id := tm.goals.Add(request.ChatID, goal)
+		return l.Text("goalAdded", id, goal.Name, l.Roubles(formatSum(goal.Target)), goal.Deadline), nil
+	case "list", "список":
+		goals := tm.goals.List(request.ChatID)
+		if len(goals) == 0 {
+			return l.Text("noGoals"), nil
+		}
+		var lines []string
+		for _, goal := range goals {
+			progress, err := tm.goalProgress(goal, tm.today())
+			if err != nil {
+				return "", err
+			}
+			lines = append(lines, formatGoal(l, goal, progress))
+		}
+		return strings.Join(lines, "\n"), nil
+	case "remove", "удалить":
+		if len(request.Args) != 2 {
+			return usage, nil
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(request.Args[1], "#"))
+		if err != nil || !tm.goals.Remove(request.ChatID, id) {
+			return l.Text("unknownGoal", request.Args[1]), nil
+		}
+		return l.Text("goalRemoved", id), nil
+	default:
+		return usage, nil
+	}
+}
+
+// monthsBetween counts the months from the first date to the second one, negative when the second is before
+func monthsBetween(from time.Time, to time.Time) int {
+	return (to.Year()-from.Year())*12 + int(to.Month()) - int(from.Month())
+}
+
+func formatSum(sum float64) string {
+	return strconv.FormatFloat(sum, 'f', -1, 64)
+}
@@ -0,0 +1,298 @@
+// Package sheetstest provides a fake Google Sheets v4 API for tests.
+// It implements the subset of the REST API used by the bot: values.get,
+// values.update, values.batchGet, values.batchUpdate and spreadsheets.get.
+package sheetstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// Write is a single values update received by the server
+type Write struct {
+	Range  string
+	Values [][]interface{}
+}
+
+// Server is a fake Sheets API serving one spreadsheet backed by a Workbook
+type Server struct {
+	// OnWrite is called after every update, e.g. to recalculate balance cells like the template formulas do
+	OnWrite func(wb *Workbook)
+
+	spreadsheetID string
+	server        *httptest.Server
+	mu            sync.Mutex
+	workbook      *Workbook
+	writes        []Write
+}
+
+// NewServer starts a fake Sheets API serving the workbook as spreadsheetID
+func NewServer(spreadsheetID string, workbook *Workbook) *Server {
+	s := &Server{spreadsheetID: spreadsheetID, workbook: workbook}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// URL returns the endpoint to pass to option.WithEndpoint
+func (s *Server) URL() string {
+	return s.server.URL + "/"
+}
+
+// Close shuts the server down
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Writes returns all updates received so far
+func (s *Server) Writes() []Write {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Write(nil), s.writes...)
+}
+
+// Cell returns the value of the cell in A1 notation, e.g. "Январь!H2", or nil if it is empty or invalid
+func (s *Server) Cell(a1 string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	title, address, err := splitCell(a1)
+	if err != nil {
+		return nil
+	}
+	sheet := s.workbook.Sheet(title)
+	if sheet == nil {
+		return nil
+	}
+	return sheet.Cell(address)
+}
+
+// SetCell sets the value of the cell in A1 notation, the sheet is added when there is none
+func (s *Server) SetCell(a1 string, value interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	title, address, err := splitCell(a1)
+	if err != nil {
+		return err
+	}
+	sheet := s.workbook.Sheet(title)
+	if sheet == nil {
+		sheet = s.workbook.AddSheet(title)
+	}
+	return sheet.SetCell(address, value)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v4/spreadsheets/")
+	if path == r.URL.Path {
+		s.fail(w, http.StatusNotFound, "NOT_FOUND", "Unknown method "+r.URL.Path)
+		return
+	}
+	id, method := path, ""
+	if i := strings.IndexAny(path, "/:"); i != -1 {
+		id, method = path[:i], path[i:]
+	}
+	if id != s.spreadsheetID {
+		s.fail(w, http.StatusNotFound, "NOT_FOUND", "Requested entity was not found.")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case method == "" && r.Method == http.MethodGet:
+		s.getSpreadsheet(w)
+	case method == "/values:batchGet" && r.Method == http.MethodGet:
+		s.batchGet(w, r)
+	case method == "/values:batchUpdate" && r.Method == http.MethodPost:
+		s.batchUpdate(w, r)
+	case strings.HasPrefix(method, "/values/") && r.Method == http.MethodGet:
+		s.get(w, r, strings.TrimPrefix(method, "/values/"))
+	case strings.HasPrefix(method, "/values/") && r.Method == http.MethodPut:
+		s.update(w, r, strings.TrimPrefix(method, "/values/"))
+	default:
+		s.fail(w, http.StatusNotFound, "NOT_FOUND", "Unsupported method "+r.Method+" "+r.URL.Path)
+	}
+}
+
+func (s *Server) getSpreadsheet(w http.ResponseWriter) {
+	spreadsheet := &sheets.Spreadsheet{
+		SpreadsheetId: s.spreadsheetID,
+		Properties:    &sheets.SpreadsheetProperties{Title: s.workbook.Title, Locale: "ru_RU"},
+	}
+	for i, sheet := range s.workbook.Sheets {
+		spreadsheet.Sheets = append(spreadsheet.Sheets, &sheets.Sheet{
+			Properties: &sheets.SheetProperties{SheetId: int64(i), Index: int64(i), Title: sheet.Title},
+		})
+	}
+	s.reply(w, spreadsheet)
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, a1 string) {
+	valueRange, err := s.read(a1, r.URL.Query().Get("valueRenderOption"))
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+		return
+	}
+	s.reply(w, valueRange)
+}
+
+func (s *Server) batchGet(w http.ResponseWriter, r *http.Request) {
+	response := &sheets.BatchGetValuesResponse{SpreadsheetId: s.spreadsheetID}
+	for _, a1 := range r.URL.Query()["ranges"] {
+		valueRange, err := s.read(a1, r.URL.Query().Get("valueRenderOption"))
+		if err != nil {
+			s.fail(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+			return
+		}
+		response.ValueRanges = append(response.ValueRanges, valueRange)
+	}
+	s.reply(w, response)
+}
+
+func (s *Server) update(w http.ResponseWriter, r *http.Request, a1 string) {
+	var valueRange sheets.ValueRange
+	if err := json.NewDecoder(r.Body).Decode(&valueRange); err != nil {
+		s.fail(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+		return
+	}
+	response, err := s.write(a1, &valueRange, r.URL.Query().Get("valueInputOption"))
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+		return
+	}
+	s.afterWrite()
+	s.reply(w, response)
+}
+
+func (s *Server) batchUpdate(w http.ResponseWriter, r *http.Request) {
+	var request sheets.BatchUpdateValuesRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.fail(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+		return
+	}
+	response := &sheets.BatchUpdateValuesResponse{SpreadsheetId: s.spreadsheetID}
+	updatedSheets := make(map[string]bool)
+	for _, valueRange := range request.Data {
+		updated, err := s.write(valueRange.Range, valueRange, request.ValueInputOption)
+		if err != nil {
+			s.fail(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+			return
+		}
+		response.Responses = append(response.Responses, updated)
+		response.TotalUpdatedCells += updated.UpdatedCells
+		response.TotalUpdatedRows += updated.UpdatedRows
+		response.TotalUpdatedColumns += updated.UpdatedColumns
+		updatedSheets[strings.SplitN(updated.UpdatedRange, "!", 2)[0]] = true
+	}
+	response.TotalUpdatedSheets = int64(len(updatedSheets))
+	s.afterWrite()
+	s.reply(w, response)
+}
+
+func (s *Server) read(a1 string, renderOption string) (*sheets.ValueRange, error) {
+	gr, err := parseRange(a1)
+	if err != nil {
+		return nil, err
+	}
+	sheet := s.workbook.Sheet(gr.sheet)
+	if sheet == nil {
+		return nil, fmt.Errorf("Unable to parse range: %s", a1)
+	}
+	render := formatValue
+	if renderOption == "UNFORMATTED_VALUE" {
+		render = func(value interface{}) interface{} { return value }
+	}
+	return &sheets.ValueRange{
+		Range:          gr.String(),
+		MajorDimension: "ROWS",
+		Values:         sheet.read(gr, render),
+	}, nil
+}
+
+func (s *Server) write(a1 string, valueRange *sheets.ValueRange, inputOption string) (*sheets.UpdateValuesResponse, error) {
+	if inputOption != "RAW" && inputOption != "USER_ENTERED" {
+		return nil, fmt.Errorf("Invalid valueInputOption: %q", inputOption)
+	}
+	gr, err := parseRange(a1)
+	if err != nil {
+		return nil, err
+	}
+	sheet := s.workbook.Sheet(gr.sheet)
+	if sheet == nil {
+		return nil, fmt.Errorf("Unable to parse range: %s", a1)
+	}
+	parse := func(value interface{}) interface{} { return value }
+	if inputOption == "USER_ENTERED" {
+		parse = parseUserEntered
+	}
+	rows, columns, cells := sheet.write(gr, valueRange.Values, parse)
+	written := gr
+	written.endRow = gr.startRow + rows
+	written.endColumn = gr.startColumn + columns
+	s.writes = append(s.writes, Write{Range: written.String(), Values: valueRange.Values})
+	return &sheets.UpdateValuesResponse{
+		SpreadsheetId:  s.spreadsheetID,
+		UpdatedRange:   written.String(),
+		UpdatedRows:    int64(rows),
+		UpdatedColumns: int64(columns),
+		UpdatedCells:   int64(cells),
+	}, nil
+}
+
+func (s *Server) afterWrite() {
+	if s.OnWrite != nil {
+		s.OnWrite(s.workbook)
+	}
+}
+
+func (s *Server) reply(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(body)
+}
+
+func (s *Server) fail(w http.ResponseWriter, code int, status string, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": message, "status": status},
+	})
+}
+
+func splitCell(a1 string) (sheet string, address string, err error) {
+	i := strings.LastIndex(a1, "!")
+	if i == -1 {
+		return "", "", fmt.Errorf("cell %s has no sheet name", a1)
+	}
+	return strings.Trim(a1[:i], "'"), a1[i+1:], nil
+}
+
+// formatValue renders the value as FORMATTED_VALUE does for plain number cells
+func formatValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// parseUserEntered turns numeric strings into numbers like the Sheets UI does
+func parseUserEntered(value interface{}) interface{} {
+	text, ok := value.(string)
+	if !ok {
+		return value
+	}
+	if number, err := strconv.ParseFloat(text, 64); err == nil {
+		return number
+	}
+	return text
+}
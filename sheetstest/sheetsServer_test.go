@@ -0,0 +1,74 @@
+package sheetstest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+func newService(t *testing.T, server *Server) *sheets.Service {
+	service, err := sheets.NewService(context.Background(),
+		option.WithHTTPClient(http.DefaultClient), option.WithEndpoint(server.URL()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func TestServerReadsAndWrites(t *testing.T) {
+	server := NewServer("test", TinkoffTemplate("2026", 1000))
+	defer server.Close()
+	service := newService(t, server)
+
+	request := &sheets.BatchUpdateValuesRequest{ValueInputOption: "RAW", Data: []*sheets.ValueRange{
+		{Range: "Март!H16:I16", Values: [][]interface{}{{"кофе", 120.5}}},
+	}}
+	if _, err := service.Spreadsheets.Values.BatchUpdate("test", request).Do(); err != nil {
+		t.Fatal(err)
+	}
+	formatted, err := service.Spreadsheets.Values.Get("test", "Март!I16").Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formatted.Values[0][0] != "120.5" {
+		t.Errorf("formatted value = %#v, want %q", formatted.Values[0][0], "120.5")
+	}
+	unformatted, err := service.Spreadsheets.Values.BatchGet("test").Ranges("Март!H16:I16").
+		ValueRenderOption("UNFORMATTED_VALUE").Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row := unformatted.ValueRanges[0].Values[0]; row[0] != "кофе" || row[1] != 120.5 {
+		t.Errorf("unformatted row = %#v, want кофе and 120.5", row)
+	}
+}
+
+func TestServerRejectsInvalidRanges(t *testing.T) {
+	server := NewServer("test", TinkoffTemplate("2026", 1000))
+	defer server.Close()
+	service := newService(t, server)
+
+	for _, a1 := range []string{"Март!A1:B2:C3", "Март!1A", "Март!A0", "Март!A100000", "Нет!A1", "!A1"} {
+		_, err := service.Spreadsheets.Values.Get("test", a1).Do()
+		if apiError, ok := err.(*googleapi.Error); !ok || apiError.Code != http.StatusBadRequest {
+			t.Errorf("get %s error = %v, want 400", a1, err)
+		}
+		request := &sheets.BatchUpdateValuesRequest{ValueInputOption: "RAW", Data: []*sheets.ValueRange{
+			{Range: a1, Values: [][]interface{}{{"кофе"}}},
+		}}
+		_, err = service.Spreadsheets.Values.BatchUpdate("test", request).Do()
+		if apiError, ok := err.(*googleapi.Error); !ok || apiError.Code != http.StatusBadRequest {
+			t.Errorf("update %s error = %v, want 400", a1, err)
+		}
+	}
+	if err := server.SetCell("H2", "кофе"); err == nil {
+		t.Error("SetCell without a sheet name has no error")
+	}
+	if value := server.Cell("Март"); value != nil {
+		t.Errorf("Cell without an address = %#v, want nil", value)
+	}
+}
@@ -0,0 +1,304 @@
+package sheetstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Months are the sheet titles of the Tinkoff template in calendar order
+var Months = []string{
+	"Январь", "Февраль", "Март", "Апрель", "Май", "Июнь",
+	"Июль", "Август", "Сентябрь", "Октябрь", "Ноябрь", "Декабрь",
+}
+
+// Workbook is an in-memory spreadsheet
+type Workbook struct {
+	Title  string
+	Sheets []*Sheet
+}
+
+// Sheet is a single tab of the workbook, cells are addressed by zero-based row and column
+type Sheet struct {
+	Title string
+	cells map[cell]interface{}
+}
+
+type cell struct {
+	row    int
+	column int
+}
+
+// gridRange is a parsed A1 notation range, ends are exclusive
+type gridRange struct {
+	sheet       string
+	startRow    int
+	startColumn int
+	endRow      int
+	endColumn   int
+}
+
+// fixture is the JSON form of a workbook: sheet titles mapped to A1 cells and their values
+type fixture struct {
+	Title  string                            `json:"title"`
+	Sheets map[string]map[string]interface{} `json:"sheets"`
+	Order  []string                          `json:"order"`
+}
+
+// NewWorkbook creates an empty workbook with the sheets
+func NewWorkbook(title string, sheets ...string) *Workbook {
+	wb := &Workbook{Title: title}
+	for _, sheet := range sheets {
+		wb.AddSheet(sheet)
+	}
+	return wb
+}
+
+// LoadWorkbook reads a JSON fixture like
+// {"title": "2026", "order": ["Январь"], "sheets": {"Январь": {"K2": "1000", "D21": 5000}}}
+func LoadWorkbook(r io.Reader) (*Workbook, error) {
+	var f fixture
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, err
+	}
+	wb := NewWorkbook(f.Title, f.Order...)
+	for title, cells := range f.Sheets {
+		sheet := wb.Sheet(title)
+		if sheet == nil {
+			sheet = wb.AddSheet(title)
+		}
+		for a1, value := range cells {
+			row, column, err := parseCell(a1)
+			if err != nil {
+				return nil, fmt.Errorf("sheet %s: %v", title, err)
+			}
+			sheet.set(row, column, value)
+		}
+	}
+	return wb, nil
+}
+
+// TinkoffTemplate builds a workbook shaped like the Tinkoff table: a sheet per month,
+// expenses in H:I of rows 2-32, daily balance in K, monthly balance in K33
+// and monthly accumulation in D21
+func TinkoffTemplate(title string, dailyBudget float64) *Workbook {
+	// Rows and columns are zero-based, e.g. K33 is the row 32 of the column 10
+	const columnC, columnD, columnH, columnI, columnK = 2, 3, 7, 8, 10
+	wb := NewWorkbook(title, Months...)
+	for _, sheet := range wb.Sheets {
+		sheet.set(0, columnH, "Описание")
+		sheet.set(0, columnI, "Сумма")
+		sheet.set(0, columnK, "Остаток")
+		for day := 1; day <= 31; day++ {
+			sheet.set(day, columnK, dailyBudget)
+		}
+		sheet.set(32, columnK, dailyBudget*31)
+		sheet.set(20, columnC, "Накопления")
+		sheet.set(20, columnD, 0)
+	}
+	return wb
+}
+
+// AddSheet appends an empty sheet
+func (wb *Workbook) AddSheet(title string) *Sheet {
+	sheet := &Sheet{Title: title, cells: make(map[cell]interface{})}
+	wb.Sheets = append(wb.Sheets, sheet)
+	return sheet
+}
+
+// Sheet returns the sheet with the title or nil
+func (wb *Workbook) Sheet(title string) *Sheet {
+	for _, sheet := range wb.Sheets {
+		if sheet.Title == title {
+			return sheet
+		}
+	}
+	return nil
+}
+
+// Cell returns the value of the A1 cell or nil if it is empty
+func (s *Sheet) Cell(a1 string) interface{} {
+	row, column, err := parseCell(a1)
+	if err != nil {
+		return nil
+	}
+	return s.cells[cell{row, column}]
+}
+
+// SetCell sets the value of the A1 cell, nil or an empty string clears it
+func (s *Sheet) SetCell(a1 string, value interface{}) error {
+	row, column, err := parseCell(a1)
+	if err != nil {
+		return err
+	}
+	s.set(row, column, value)
+	return nil
+}
+
+func (s *Sheet) set(row int, column int, value interface{}) {
+	if value == nil || value == "" {
+		delete(s.cells, cell{row, column})
+		return
+	}
+	s.cells[cell{row, column}] = value
+}
+
+// size returns the number of rows and columns which contain values
+func (s *Sheet) size() (rows int, columns int) {
+	for c := range s.cells {
+		if c.row+1 > rows {
+			rows = c.row + 1
+		}
+		if c.column+1 > columns {
+			columns = c.column + 1
+		}
+	}
+	return rows, columns
+}
+
+// read returns the values of the range with trailing empty rows and columns removed like the API does
+func (s *Sheet) read(gr gridRange, render func(interface{}) interface{}) [][]interface{} {
+	rows, columns := s.size()
+	endRow, endColumn := gr.endRow, gr.endColumn
+	if endRow > rows {
+		endRow = rows
+	}
+	if endColumn > columns {
+		endColumn = columns
+	}
+	var values [][]interface{}
+	for row := gr.startRow; row < endRow; row++ {
+		var line []interface{}
+		last := -1
+		for column := gr.startColumn; column < endColumn; column++ {
+			value, ok := s.cells[cell{row, column}]
+			if !ok {
+				line = append(line, "")
+				continue
+			}
+			line = append(line, render(value))
+			last = column - gr.startColumn
+		}
+		values = append(values, line[:last+1])
+	}
+	for len(values) > 0 && len(values[len(values)-1]) == 0 {
+		values = values[:len(values)-1]
+	}
+	return values
+}
+
+// write puts the values starting at the top left corner of the range and returns the written area
+func (s *Sheet) write(gr gridRange, values [][]interface{}, parse func(interface{}) interface{}) (rows int, columns int, cells int) {
+	for i, line := range values {
+		if len(line) > columns {
+			columns = len(line)
+		}
+		for j, value := range line {
+			s.set(gr.startRow+i, gr.startColumn+j, parse(value))
+			cells++
+		}
+	}
+	return len(values), columns, cells
+}
+
+// parseRange parses A1 notation: Sheet, Sheet!A1, Sheet!A1:B2 or 'Sheet name'!A:B
+func parseRange(a1 string) (gridRange, error) {
+	gr := gridRange{endRow: maxSize, endColumn: maxSize}
+	sheet, cells := a1, ""
+	if i := strings.LastIndex(a1, "!"); i != -1 {
+		sheet, cells = a1[:i], a1[i+1:]
+	}
+	gr.sheet = strings.Trim(sheet, "'")
+	if cells == "" {
+		return gr, nil
+	}
+	parts := strings.Split(cells, ":")
+	if len(parts) > 2 {
+		return gr, fmt.Errorf("Unable to parse range: %s", a1)
+	}
+	startRow, startColumn, err := parseBound(parts[0])
+	if err != nil {
+		return gr, fmt.Errorf("Unable to parse range: %s", a1)
+	}
+	endRow, endColumn := startRow, startColumn
+	if len(parts) == 2 {
+		if endRow, endColumn, err = parseBound(parts[1]); err != nil {
+			return gr, fmt.Errorf("Unable to parse range: %s", a1)
+		}
+	}
+	if startRow >= 0 {
+		gr.startRow = startRow
+	}
+	if startColumn >= 0 {
+		gr.startColumn = startColumn
+	}
+	if endRow >= 0 {
+		gr.endRow = endRow + 1
+	}
+	if endColumn >= 0 {
+		gr.endColumn = endColumn + 1
+	}
+	return gr, nil
+}
+
+// String formats the range back to A1 notation
+func (gr gridRange) String() string {
+	result := gr.sheet + "!" + columnName(gr.startColumn) + strconv.Itoa(gr.startRow+1)
+	if gr.endRow-gr.startRow == 1 && gr.endColumn-gr.startColumn == 1 {
+		return result
+	}
+	return result + ":" + columnName(gr.endColumn-1) + strconv.Itoa(gr.endRow)
+}
+
+const maxSize = 1000
+
+// parseBound parses A1, A or 1, a missing part is returned as -1.
+// Bounds beyond maxSize rows or columns are rejected like the API rejects the ones beyond the grid.
+func parseBound(bound string) (row int, column int, err error) {
+	i := 0
+	for i < len(bound) && bound[i] >= 'A' && bound[i] <= 'Z' {
+		i++
+	}
+	column, row = -1, -1
+	if i > 0 {
+		column = 0
+		for _, letter := range bound[:i] {
+			if column = column*26 + int(letter-'A') + 1; column > maxSize {
+				return 0, 0, fmt.Errorf("cell %s exceeds grid limits", bound)
+			}
+		}
+		column--
+	}
+	if i < len(bound) {
+		number, err := strconv.Atoi(bound[i:])
+		if err != nil || number < 1 {
+			return 0, 0, fmt.Errorf("invalid cell %s", bound)
+		}
+		if number > maxSize {
+			return 0, 0, fmt.Errorf("cell %s exceeds grid limits", bound)
+		}
+		row = number - 1
+	}
+	if row == -1 && column == -1 {
+		return 0, 0, fmt.Errorf("invalid cell %s", bound)
+	}
+	return row, column, nil
+}
+
+func parseCell(a1 string) (row int, column int, err error) {
+	row, column, err = parseBound(a1)
+	if err == nil && (row == -1 || column == -1) {
+		err = fmt.Errorf("invalid cell %s", a1)
+	}
+	return row, column, err
+}
+
+func columnName(column int) string {
+	name := ""
+	for column++; column > 0; column = (column - 1) / 26 {
+		name = string(rune('A'+(column-1)%26)) + name
+	}
+	return name
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// mentionPattern matches a Telegram username mention, e.g. @masha
+var mentionPattern = regexp.MustCompile(`^@[A-Za-z0-9_]{3,32}$`)
+
+// Debt is what one member of the chat owes to another
+type Debt struct {
+	From   string
+	To     string
+	Amount float64
+}
+
+// SplitLedger keeps who owes whom in every chat in a file, empty path keeps it in memory only.
+// The debts of two members are netted, so only one of them owes the other.
+type SplitLedger struct {
+	path  string
+	mu    sync.Mutex
+	chats map[string]map[string]map[string]float64
+}
+
+// NewSplitLedger creates new SplitLedger instant and loads the debts saved before
+func NewSplitLedger(path string) (*SplitLedger, error) {
+	sl := &SplitLedger{path: path, chats: make(map[string]map[string]map[string]float64)}
+	if path == "" {
+		return sl, nil
+	}
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return sl, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bytes, &sl.chats); err != nil {
+		return nil, fmt.Errorf("could not parse split ledger %s: %v", path, err)
+	}
+	return sl, nil
+}
+
+// Add records that every debtor owes the amount to the creditor
+func (sl *SplitLedger) Add(chatID int64, creditor string, debtors []string, amount float64) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	key := strconv.FormatInt(chatID, 10)
+	debts, ok := sl.chats[key]
+	if !ok {
+		debts = make(map[string]map[string]float64)
+		sl.chats[key] = debts
+	}
+	for _, debtor := range debtors {
+		// Positive is what the creditor still owes the debtor after the netting
+		owed := math.Round((debts[creditor][debtor]-debts[debtor][creditor]-amount)*100) / 100
+		delete(debts[creditor], debtor)
+		delete(debts[debtor], creditor)
+		switch {
+		case owed > 0:
+			sl.set(debts, creditor, debtor, owed)
+		case owed < 0:
+			sl.set(debts, debtor, creditor, -owed)
+		}
+	}
+	sl.save()
+}
+
+// Debts returns the debts of the chat ordered by the debtor and the creditor
+func (sl *SplitLedger) Debts(chatID int64) []Debt {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	var list []Debt
+	for from, creditors := range sl.chats[strconv.FormatInt(chatID, 10)] {
+		for to, amount := range creditors {
+			list = append(list, Debt{From: from, To: to, Amount: amount})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].From != list[j].From {
+			return list[i].From < list[j].From
+		}
+		return list[i].To < list[j].To
+	})
+	return list
+}
+
+// Settle clears the debts between the two members, ok is false when there were none
+func (sl *SplitLedger) Settle(chatID int64, first string, second string) bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	debts := sl.chats[strconv.FormatInt(chatID, 10)]
+	_, owes := debts[first][second]
+	_, owed := debts[second][first]
+	if !owes && !owed {
+		return false
+	}
+	delete(debts[first], second)
+	delete(debts[second], first)
+	sl.save()
+	return true
+}
+
+// SettleAll clears every debt of the chat
+func (sl *SplitLedger) SettleAll(chatID int64) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	delete(sl.chats, strconv.FormatInt(chatID, 10))
+	sl.save()
+}
+
+func (sl *SplitLedger) set(debts map[string]map[string]float64, debtor string, creditor string, amount float64) {
+	if _, ok := debts[debtor]; !ok {
+		debts[debtor] = make(map[string]float64)
+	}
+	debts[debtor][creditor] = amount
+}
+
+func (sl *SplitLedger) save() {
+	if sl.path == "" {
+		return
+	}
+	bytes, err := json.Marshal(sl.chats)
+	if err == nil {
+		err = writeFileAtomically(sl.path, bytes)
+	}
+	if err != nil {
+		log.Printf("Could not save split ledger: %v", err)
+	}
+}
+
+// splitMentions removes the mentions from the text and returns the mentioned members once each,
+// e.g. "ужин 3000 @masha @petya" is "ужин 3000" shared with @masha and @petya
+func splitMentions(text string) (string, []string) {
+	var members []string
+	seen := make(map[string]bool)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		var words []string
+		for _, word := range strings.Fields(line) {
+			if !mentionPattern.MatchString(word) {
+				words = append(words, word)
+				continue
+			}
+			member := strings.ToLower(word)
+			if !seen[member] {
+				seen[member] = true
+				members = append(members, member)
+			}
+		}
+		lines[i] = strings.Join(words, " ")
+	}
+	return strings.Join(lines, "\n"), members
+}
+
+// memberName names the user in the ledger the same way the others mention them
+func memberName(user *tgbotapi.User) string {
+	switch {
+	case user == nil:
+		return ""
+	case user.UserName != "":
+		return "@" + strings.ToLower(user.UserName)
+	case user.FirstName != "":
+		return user.FirstName
+	default:
+		return strconv.Itoa(user.ID)
+	}
+}
+
+// shareMembers drops the payer and the bot from the mentioned members, nobody shares with an unknown payer
+func (tm *TableManagement) shareMembers(mentioned []string, payer string) []string {
+	if payer == "" {
+		return nil
+	}
+	var members []string
+	for _, member := range mentioned {
+		if member != payer && member != "@"+strings.ToLower(tm.commands.BotName) {
+			members = append(members, member)
+		}
+	}
+	return members
+}
+
+// shareOf is the part of the sum every one of the people pays
+func shareOf(sum float64, people int) float64 {
+	return math.Round(sum/float64(people)*100) / 100
+}
+
+// formatDebts lists the debts for the reply
+func formatDebts(l *Localizer, debts []Debt) string {
+	var lines []string
+	for _, debt := range debts {
+		lines = append(lines, l.Text("debt", debt.From, debt.To, l.Roubles(strconv.FormatFloat(debt.Amount, 'f', -1, 64))))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// settleCommand shows the debts of the chat, "/settle @masha" clears the ones between the user and @masha
+// and "/settle all" clears every debt of the chat
+func settleCommand(tm *TableManagement, request *CommandRequest) (string, error) {
+	l := request.Localizer
+	if len(request.Args) == 0 {
+		debts := tm.ledger.Debts(request.ChatID)
+		if len(debts) == 0 {
+			return l.Text("noDebts"), nil
+		}
+		return l.Text("debts") + "\n" + formatDebts(l, debts), nil
+	}
+	switch argument := strings.ToLower(request.Args[0]); {
+	case argument == "all" || argument == "все":
+		tm.ledger.SettleAll(request.ChatID)
+		return l.Text("settledAll"), nil
+	case mentionPattern.MatchString(argument):
+		member := memberName(request.User)
+		if !tm.ledger.Settle(request.ChatID, member, argument) {
+			return l.Text("noDebtsBetween", member, argument), nil
+		}
+		return l.Text("settled", member, argument), nil
+	default:
+		return l.Text("usage", tm.commands.usage(l, tm.commands.byName["settle"])), nil
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestSplitLedgerNetting(t *testing.T) {
+	ledger, _ := NewSplitLedger("")
+	steps := []struct {
+		creditor string
+		debtor   string
+		amount   float64
+		debts    []Debt
+	}{
+		{"@petya", "@masha", 1500, []Debt{{From: "@masha", To: "@petya", Amount: 1500}}},
+		{"@petya", "@masha", 100, []Debt{{From: "@masha", To: "@petya", Amount: 1600}}},
+		{"@masha", "@petya", 600, []Debt{{From: "@masha", To: "@petya", Amount: 1000}}},
+		{"@masha", "@petya", 1000, nil},
+		{"@masha", "@petya", 200.5, []Debt{{From: "@petya", To: "@masha", Amount: 200.5}}},
+	}
+	for i, step := range steps {
+		ledger.Add(1, step.creditor, []string{step.debtor}, step.amount)
+		if debts := ledger.Debts(1); !reflect.DeepEqual(debts, step.debts) {
+			t.Fatalf("step %d: debts = %+v, want %+v", i+1, debts, step.debts)
+		}
+	}
+	if debts := ledger.Debts(2); len(debts) != 0 {
+		t.Errorf("debts of another chat = %+v, want none", debts)
+	}
+	if !ledger.Settle(1, "@masha", "@petya") || len(ledger.Debts(1)) != 0 {
+		t.Errorf("debts after settling = %+v, want none", ledger.Debts(1))
+	}
+}
+
+func TestRecordExpensesSplitsPerLine(t *testing.T) {
+	tm, server := newSheetsManagement(t)
+	defer server.Close()
+	tm.commands.BotName = "test_bot"
+	user := &tgbotapi.User{ID: 1, FirstName: "Петя", UserName: "Petya"}
+
+	recordExpenses(tm, NewLocalizer("ru"), 42, user, "кофе 150\nужин 3000 @masha @test_bot @petya")
+	// The coffee is the user's own, the dinner is shared with @masha only
+	if value := server.Cell("Март!I16"); value != 1650.0 {
+		t.Errorf("day sum = %#v, want 1650", value)
+	}
+	want := []Debt{{From: "@masha", To: "@petya", Amount: 1500}}
+	if debts := tm.ledger.Debts(42); !reflect.DeepEqual(debts, want) {
+		t.Errorf("debts = %+v, want %+v", debts, want)
+	}
+}
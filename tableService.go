@@ -1,21 +1,23 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
+	"encoding/base64"
+	"errors"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
 	"time"
 
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 
+	"google.golang.org/api/option"
 	"google.golang.org/api/sheets/v4"
 )
 
+const sheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+
 // ConnectionProperties holds all properties needed to create a connection
 type ConnectionProperties struct {
 	SpreadsheetID string
@@ -29,6 +31,16 @@ type ConnectionProperties struct {
 	TokenType string
 	RefreshToken string
 	ExpireTime string
+	ServiceAccountFile string
+	ServiceAccountKey string
+	Endpoint string
+	// WrapTransport optionally decorates the HTTP transport, e.g. to record the requests
+	WrapTransport func(base http.RoundTripper) http.RoundTripper
+}
+
+func (properties *ConnectionProperties) hasCredentials() bool {
+	return properties.ServiceAccountFile != "" || properties.ServiceAccountKey != "" ||
+		properties.ClientID != "" || properties.AccessToken != ""
 }
 
 // TableService creates a connection and simplify interactions with it
@@ -38,17 +50,40 @@ type TableService struct {
 }
 
 // NewTableService factory method to create a TableService
-func NewTableService(properties *ConnectionProperties) (*TableService, error) {
+func NewTableService(properties *ConnectionProperties, store TokenStore) (*TableService, error) {
 	ts := &TableService{}
-	config, err := ts.getConfig(properties)
-	if err != nil {
-		return nil, err
+	var client *http.Client
+	if properties.Endpoint != "" && !properties.hasCredentials() {
+		// A local backend like sheetstest does not check authorization
+		client = http.DefaultClient
+	} else if properties.ServiceAccountFile != "" || properties.ServiceAccountKey != "" {
+		serviceClient, err := ts.getServiceAccountClient(properties)
+		if err != nil {
+			return nil, err
+		}
+		client = serviceClient
+	} else {
+		config, err := ts.getConfig(properties)
+		if err != nil {
+			return nil, err
+		}
+		client, err = ts.getClient(properties, config, store)
+		if err != nil {
+			return nil, err
+		}
 	}
-	client, err := ts.getClient(properties, config) 
-	if err != nil {
-		return nil, err
+	if properties.WrapTransport != nil {
+		base := client.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		client = &http.Client{Transport: properties.WrapTransport(base)}
 	}
-	service, err := sheets.New(client)
+	options := []option.ClientOption{option.WithHTTPClient(client)}
+	if properties.Endpoint != "" {
+		options = append(options, option.WithEndpoint(properties.Endpoint))
+	}
+	service, err := sheets.NewService(context.Background(), options...)
 	if err != nil {
 		log.Printf("Unable to retrieve Sheets client: %v", err)
 		return nil, err
@@ -58,6 +93,33 @@ func NewTableService(properties *ConnectionProperties) (*TableService, error) {
 	return ts, nil
 }
 
+// Authorize obtains a new OAuth token in the browser and writes it to the store
+func Authorize(properties *ConnectionProperties, store TokenStore, address string, timeout time.Duration) error {
+	ts := &TableService{}
+	config, err := ts.getConfig(properties)
+	if err != nil {
+		return err
+	}
+	token, err := NewLoopbackAuth(config, address, timeout).Token(context.Background())
+	if err != nil {
+		return err
+	}
+	return store.Save(token)
+}
+
+// Check verifies that the spreadsheet exists and is accessible
+func (ts *TableService) Check() error {
+	_, err := ts.service.Spreadsheets.Get(ts.SpreadsheetID).Fields("spreadsheetId").Do()
+	return err
+}
+
+// ForSpreadsheet returns the service working with another spreadsheet over the same connection
+func (ts *TableService) ForSpreadsheet(spreadsheetID string) *TableService {
+	other := *ts
+	other.SpreadsheetID = spreadsheetID
+	return &other
+}
+
 // GetData from the workingRange cells
 func (ts *TableService) GetData(workingRange string) (*sheets.ValueRange, error) {
 	return ts.service.Spreadsheets.Values.Get(ts.SpreadsheetID, workingRange).Do()
@@ -68,15 +130,52 @@ func (ts *TableService) UpdateData(workingRange string, resultRange *sheets.Valu
 	return ts.service.Spreadsheets.Values.Update(ts.SpreadsheetID, workingRange, resultRange).ValueInputOption("RAW").Do()
 }
 
+// BatchGetData from several ranges in one request, the ranges come back in the same order.
+// The values are unformatted, so the numbers come as float64 whatever the locale of the spreadsheet is.
+func (ts *TableService) BatchGetData(workingRanges []string) ([]*sheets.ValueRange, error) {
+	response, err := ts.service.Spreadsheets.Values.BatchGet(ts.SpreadsheetID).Ranges(workingRanges...).
+		ValueRenderOption("UNFORMATTED_VALUE").Do()
+	if err != nil {
+		return nil, err
+	}
+	return response.ValueRanges, nil
+}
+
+// BatchUpdateData in several ranges in one request
+func (ts *TableService) BatchUpdateData(resultRanges []*sheets.ValueRange) (*sheets.BatchUpdateValuesResponse, error) {
+	request := &sheets.BatchUpdateValuesRequest{ValueInputOption: "RAW", Data: resultRanges}
+	return ts.service.Spreadsheets.Values.BatchUpdate(ts.SpreadsheetID, request).Do()
+}
+
+func (ts *TableService) getServiceAccountClient(properties *ConnectionProperties) (*http.Client, error) {
+	var keyBytes []byte
+	var err error
+	if properties.ServiceAccountFile != "" {
+		keyBytes, err = ioutil.ReadFile(properties.ServiceAccountFile)
+	} else {
+		keyBytes, err = base64.StdEncoding.DecodeString(properties.ServiceAccountKey)
+	}
+	if err != nil {
+		log.Printf("Unable to read service account key: %v", err)
+		return nil, err
+	}
+	config, err := google.JWTConfigFromJSON(keyBytes, sheetsScope)
+	if err != nil {
+		log.Printf("Unable to parse service account key: %v", err)
+		return nil, err
+	}
+	log.Printf("Authenticating as service account %s", config.Email)
+	return config.Client(context.Background()), nil
+}
+
 func (ts *TableService) getConfig(properties *ConnectionProperties) (*oauth2.Config, error) {
-	scope := "https://www.googleapis.com/auth/spreadsheets"
 	if properties.ClientID != "" && properties.ProjectID != "" && properties.AuthURI != "" &&
 	properties.TokenURI != "" && properties.ClientSecret != "" && properties.RedirectUris != "" {
 		return &oauth2.Config {
 			ClientID:     properties.ClientID,
 			ClientSecret: properties.ClientSecret,
 			RedirectURL:  properties.RedirectUris,
-			Scopes:       []string{scope},
+			Scopes:       []string{sheetsScope},
 			Endpoint: oauth2.Endpoint{
 				AuthURL:  properties.AuthURI,
 				TokenURL: properties.TokenURI,
@@ -88,7 +187,7 @@ func (ts *TableService) getConfig(properties *ConnectionProperties) (*oauth2.Con
 		log.Printf("Unable to read client secret file: %v", err)
 		return nil, err
 	}
-	config, err := google.ConfigFromJSON(credentialsBytes, scope)
+	config, err := google.ConfigFromJSON(credentialsBytes, sheetsScope)
 	if err != nil {
 		log.Printf("Unable to parse client secret file to config: %v", err)
 		return nil, err
@@ -96,68 +195,27 @@ func (ts *TableService) getConfig(properties *ConnectionProperties) (*oauth2.Con
 	return config, nil
 }
 
-func (ts *TableService) getClient(properties *ConnectionProperties, config *oauth2.Config) (*http.Client, error) {
-	if properties.AccessToken != "" && properties.TokenType != "" && properties.RefreshToken != "" && properties.ExpireTime != "" {
-		expiry, _ := time.Parse(time.RFC3339, properties.ExpireTime)
-		token := &oauth2.Token{
-			AccessToken:  properties.AccessToken,
-			TokenType:    properties.TokenType,
-			RefreshToken: properties.RefreshToken,
-			Expiry:       expiry,
-		}
-		return config.Client(context.Background(), token), nil
-	}
-	tokenFile := "token.json"
-	token, err := ts.tokenFromFile(tokenFile)
+func (ts *TableService) getClient(properties *ConnectionProperties, config *oauth2.Config, store TokenStore) (*http.Client, error) {
+	token, err := store.Load()
 	if err != nil {
-		token, err = ts.getTokenFromWeb(config)
-		if err != nil {
-			return nil, err
-		}
-		err := ts.saveToken(tokenFile, token)
-		if err != nil {
-			return nil, err
-		}
+		token = ts.tokenFromProperties(properties)
 	}
-	return config.Client(context.Background(), token), nil
-}
-
-func (ts *TableService) getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the " +
-		"authorization code: \n%v\n", authURL)
-	var authCode string
-	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Printf("Unable to read authorization code: %v", err)
-		return nil, err
+	if token == nil {
+		return nil, errors.New("no OAuth token found, run the auth command to authorize the bot")
 	}
-	token, err := config.Exchange(context.TODO(), authCode)
-	if err != nil {
-		log.Printf("Unable to retrieve token from web: %v", err)
-		return nil, err
-	}
-	return token, nil
+	source := NewPersistentTokenSource(config.TokenSource(context.Background(), token), store, token)
+	return oauth2.NewClient(context.Background(), source), nil
 }
 
-func (ts *TableService) tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
+func (ts *TableService) tokenFromProperties(properties *ConnectionProperties) *oauth2.Token {
+	if properties.AccessToken == "" || properties.TokenType == "" || properties.RefreshToken == "" || properties.ExpireTime == "" {
+		return nil
 	}
-	defer f.Close()
-	tok := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(tok)
-	return tok, err
-}
-
-func (ts *TableService) saveToken(path string, token *oauth2.Token) error {
-	fmt.Printf("Saving credential file to: %s\n", path)
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		log.Printf("Unable to cache oauth token: %v", err)
-		return err
+	expiry, _ := time.Parse(time.RFC3339, properties.ExpireTime)
+	return &oauth2.Token{
+		AccessToken:  properties.AccessToken,
+		TokenType:    properties.TokenType,
+		RefreshToken: properties.RefreshToken,
+		Expiry:       expiry,
 	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
-	return nil
 }
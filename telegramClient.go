@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// NewTelegramBot connects to Telegram or, when apiURL is set, to a compatible
+// server like telegramtest. tgbotapi always builds URLs from its APIEndpoint
+// constant, so requests are redirected to apiURL on the transport level.
+func NewTelegramBot(token string, apiURL string) (*tgbotapi.BotAPI, error) {
+	if apiURL == "" {
+		return tgbotapi.NewBotAPI(token)
+	}
+	target, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: &redirectTransport{target: target}}
+	return tgbotapi.NewBotAPIWithClient(token, client)
+}
+
+// redirectTransport sends requests to the target whatever host they were made for,
+// the path of the target is kept in front of the request path
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt *redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = rt.target.Scheme
+	r.URL.Host = rt.target.Host
+	r.URL.Path = strings.TrimSuffix(rt.target.Path, "/") + r.URL.Path
+	r.URL.RawPath = ""
+	r.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
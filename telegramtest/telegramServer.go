@@ -0,0 +1,291 @@
+// Package telegramtest provides a fake Telegram Bot API for end-to-end tests.
+// It serves getMe, getUpdates, sendMessage, sendPhoto, sendDocument, editMessageText,
+// setWebhook, answerCallbackQuery and answerInlineQuery, lets tests inject updates
+// and records what the bot sends.
+package telegramtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Sent is a request the bot made to send something: a message, a file, an edit or a callback answer
+type Sent struct {
+	Method   string
+	ChatID   int64
+	Text     string
+	ReplyTo  int
+	FileName string
+	Params   url.Values
+}
+
+// Server is a fake Bot API for a single bot token
+type Server struct {
+	Bot tgbotapi.User
+
+	token      string
+	server     *httptest.Server
+	mu         sync.Mutex
+	changed    chan struct{}
+	updates    []tgbotapi.Update
+	nextUpdate int
+	nextMsg    int
+	sent       []Sent
+	webhook    string
+}
+
+// NewServer starts a fake Bot API accepting the token
+func NewServer(token string) *Server {
+	s := &Server{
+		Bot:        tgbotapi.User{ID: 1, IsBot: true, FirstName: "Test", UserName: "test_bot"},
+		token:      token,
+		changed:    make(chan struct{}),
+		nextUpdate: 1,
+		nextMsg:    1,
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// URL returns the base URL of the server, requests for api.telegram.org should be sent there,
+// e.g. by the bot created with NewTelegramBot or with TELEGRAM_API_URL
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Close shuts the server down
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Inject queues the update for getUpdates and assigns its update_id
+func (s *Server) Inject(update tgbotapi.Update) tgbotapi.Update {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	update.UpdateID = s.nextUpdate
+	s.nextUpdate++
+	s.updates = append(s.updates, update)
+	s.notify()
+	return update
+}
+
+// SendText injects a text message from the user to the chat, texts starting with / become commands
+func (s *Server) SendText(chatID int64, from tgbotapi.User, text string) tgbotapi.Update {
+	s.mu.Lock()
+	message := &tgbotapi.Message{
+		MessageID: s.nextMsg,
+		From:      &from,
+		Date:      int(time.Now().Unix()),
+		Chat:      &tgbotapi.Chat{ID: chatID, Type: "private"},
+		Text:      text,
+	}
+	s.nextMsg++
+	s.mu.Unlock()
+	if chatID < 0 {
+		message.Chat.Type = "group"
+	}
+	if strings.HasPrefix(text, "/") {
+		command := strings.SplitN(text, " ", 2)[0]
+		message.Entities = &[]tgbotapi.MessageEntity{{
+			Type:   "bot_command",
+			Length: len(utf16.Encode([]rune(command))),
+		}}
+	}
+	return s.Inject(tgbotapi.Update{Message: message})
+}
+
+// PressButton injects a press of the inline keyboard button with the callback data under the bot message
+func (s *Server) PressButton(chatID int64, from tgbotapi.User, messageID int, data string) tgbotapi.Update {
+	s.mu.Lock()
+	id := strconv.Itoa(s.nextUpdate)
+	s.mu.Unlock()
+	return s.Inject(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:   id,
+		From: &from,
+		Message: &tgbotapi.Message{
+			MessageID: messageID,
+			From:      &s.Bot,
+			Chat:      &tgbotapi.Chat{ID: chatID, Type: "private"},
+		},
+		Data: data,
+	}})
+}
+
+// Sent returns everything the bot has sent so far
+func (s *Server) Sent() []Sent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Sent(nil), s.sent...)
+}
+
+// WaitSent waits until the bot has sent at least count items or the timeout expires
+func (s *Server) WaitSent(count int, timeout time.Duration) []Sent {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		if len(s.sent) >= count {
+			sent := append([]Sent(nil), s.sent...)
+			s.mu.Unlock()
+			return sent
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-deadline:
+			return s.Sent()
+		}
+	}
+}
+
+// Webhook returns the URL registered with setWebhook
+func (s *Server) Webhook() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.webhook
+}
+
+// notify wakes up long polling requests and waiters, must be called with the lock held
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) != 2 || parts[0] != "bot"+s.token {
+		s.fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if err := r.ParseMultipartForm(1 << 20); err != nil && err != http.ErrNotMultipart {
+		s.fail(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+	switch parts[1] {
+	case "getMe":
+		s.reply(w, s.Bot)
+	case "getUpdates":
+		s.getUpdates(w, r)
+	case "sendMessage":
+		s.send(w, r, "sendMessage", "")
+	case "sendPhoto":
+		s.send(w, r, "sendPhoto", "photo")
+	case "sendDocument":
+		s.send(w, r, "sendDocument", "document")
+	case "editMessageText":
+		chatID, _ := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+		messageID, _ := strconv.Atoi(r.FormValue("message_id"))
+		s.record(Sent{Method: "editMessageText", ChatID: chatID, Text: r.FormValue("text"), Params: r.Form})
+		s.reply(w, tgbotapi.Message{MessageID: messageID, From: &s.Bot, Chat: &tgbotapi.Chat{ID: chatID}, Text: r.FormValue("text")})
+	case "setWebhook":
+		s.mu.Lock()
+		s.webhook = r.FormValue("url")
+		s.mu.Unlock()
+		s.reply(w, true)
+	case "answerCallbackQuery":
+		s.record(Sent{Method: "answerCallbackQuery", Text: r.FormValue("text"), Params: r.Form})
+		s.reply(w, true)
+	case "answerInlineQuery":
+		s.record(Sent{Method: "answerInlineQuery", Params: r.Form})
+		s.reply(w, true)
+	default:
+		s.fail(w, http.StatusNotFound, "Not Found: method not found")
+	}
+}
+
+func (s *Server) getUpdates(w http.ResponseWriter, r *http.Request) {
+	offset, _ := strconv.Atoi(r.FormValue("offset"))
+	timeout, _ := strconv.Atoi(r.FormValue("timeout"))
+	deadline := time.After(time.Duration(timeout) * time.Second)
+	for {
+		s.mu.Lock()
+		if s.webhook != "" {
+			s.mu.Unlock()
+			s.fail(w, http.StatusConflict, "Conflict: can't use getUpdates method while webhook is active")
+			return
+		}
+		// Like Telegram, updates before the offset are confirmed and forgotten
+		var pending []tgbotapi.Update
+		var kept []tgbotapi.Update
+		for _, update := range s.updates {
+			if update.UpdateID >= offset {
+				kept = append(kept, update)
+				pending = append(pending, update)
+			}
+		}
+		s.updates = kept
+		changed := s.changed
+		s.mu.Unlock()
+		if len(pending) > 0 || timeout == 0 {
+			s.reply(w, pending)
+			return
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			s.reply(w, []tgbotapi.Update{})
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) send(w http.ResponseWriter, r *http.Request, method string, fileField string) {
+	chatID, err := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, "Bad Request: chat_id is empty")
+		return
+	}
+	replyTo, _ := strconv.Atoi(r.FormValue("reply_to_message_id"))
+	sent := Sent{Method: method, ChatID: chatID, Text: r.FormValue("text"), ReplyTo: replyTo, Params: r.Form}
+	if fileField != "" {
+		sent.Text = r.FormValue("caption")
+		sent.FileName = r.FormValue(fileField)
+		if r.MultipartForm != nil {
+			if files := r.MultipartForm.File[fileField]; len(files) > 0 {
+				sent.FileName = files[0].Filename
+			}
+		}
+	}
+	s.mu.Lock()
+	message := tgbotapi.Message{
+		MessageID: s.nextMsg,
+		From:      &s.Bot,
+		Date:      int(time.Now().Unix()),
+		Chat:      &tgbotapi.Chat{ID: chatID},
+		Text:      sent.Text,
+	}
+	s.nextMsg++
+	s.mu.Unlock()
+	s.record(sent)
+	s.reply(w, message)
+}
+
+func (s *Server) record(sent Sent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, sent)
+	s.notify()
+}
+
+func (s *Server) reply(w http.ResponseWriter, result interface{}) {
+	bytes, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: true, Result: bytes})
+}
+
+func (s *Server) fail(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: false, ErrorCode: code, Description: description})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"time"
+)
+
+// forChat returns the management whose clock shows the date of the chat: the time is moved to the timezone
+// of the chat and back by the hour the day ends at, so a purchase at 01:30 with the day ending at 03:00
+// belongs to the previous day. Everything else is shared with tm.
+func (tm *TableManagement) forChat(chatID int64) *TableManagement {
+	location, dayEndsAt := tm.chatClock(chatID)
+	now := tm.now
+	chat := *tm
+	chat.now = func() time.Time {
+		return now().In(location).Add(-time.Duration(dayEndsAt) * time.Hour)
+	}
+	return &chat
+}
+
+// chatClock returns the timezone and the hour the day ends at in the chat, the defaults when it has none
+func (tm *TableManagement) chatClock(chatID int64) (*time.Location, int) {
+	settings := tm.chats.Get(chatID)
+	location := tm.location
+	if settings.Timezone != "" {
+		if chatLocation, err := time.LoadLocation(settings.Timezone); err == nil {
+			location = chatLocation
+		}
+	}
+	dayEndsAt := tm.dayEndsAt
+	if settings.DayEndsAt != nil {
+		dayEndsAt = *settings.DayEndsAt
+	}
+	return location, dayEndsAt
+}
+
+// loadLocation loads the IANA timezone, empty name is the local timezone of the server
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// validDayEnd reports whether the day can end at the hour, later hours would move the most of the day
+func validDayEnd(hour int) bool {
+	return hour >= 0 && hour <= 12
+}
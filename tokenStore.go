@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// TokenStore keeps OAuth tokens between restarts
+type TokenStore interface {
+	Load() (*oauth2.Token, error)
+	Save(token *oauth2.Token) error
+}
+
+// FileTokenStore keeps the token in a JSON file
+type FileTokenStore struct {
+	Path string
+}
+
+// NewFileTokenStore creates new FileTokenStore instant
+func NewFileTokenStore(path string) *FileTokenStore {
+	return &FileTokenStore{Path: path}
+}
+
+// Load reads the token from the file
+func (fs *FileTokenStore) Load() (*oauth2.Token, error) {
+	f, err := os.Open(fs.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	token := &oauth2.Token{}
+	err = json.NewDecoder(f).Decode(token)
+	return token, err
+}
+
+// Save writes the token to the file readable by the owner only,
+// the temporary file of writeFileAtomically is created with 0600
+func (fs *FileTokenStore) Save(token *oauth2.Token) error {
+	log.Printf("Saving credential file to: %s", fs.Path)
+	bytes, err := json.Marshal(token)
+	if err == nil {
+		err = writeFileAtomically(fs.Path, bytes)
+	}
+	if err != nil {
+		log.Printf("Unable to cache oauth token: %v", err)
+	}
+	return err
+}
+
+// persistentTokenSource saves every token received from the underlying source
+// if it differs from the previously seen one
+type persistentTokenSource struct {
+	base  oauth2.TokenSource
+	store TokenStore
+	mu    sync.Mutex
+	last  *oauth2.Token
+}
+
+// NewPersistentTokenSource wraps the source so refreshed tokens are written to the store
+func NewPersistentTokenSource(base oauth2.TokenSource, store TokenStore, current *oauth2.Token) oauth2.TokenSource {
+	return oauth2.ReuseTokenSource(current, &persistentTokenSource{base: base, store: store, last: current})
+}
+
+func (ps *persistentTokenSource) Token() (*oauth2.Token, error) {
+	token, err := ps.base.Token()
+	if err != nil {
+		return nil, err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.last == nil || ps.last.AccessToken != token.AccessToken || ps.last.RefreshToken != token.RefreshToken {
+		if err := ps.store.Save(token); err != nil {
+			// The token is still usable, it will be saved after the next refresh
+			log.Printf("Could not persist refreshed token: %v", err)
+		} else {
+			ps.last = token
+		}
+	}
+	return token, nil
+}
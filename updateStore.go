@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// UpdateStore remembers processed updates so redelivered ones are not written twice.
+// Only the last window update and message IDs are kept, the state is persisted to a file.
+type UpdateStore struct {
+	path   string
+	window int
+	mu     sync.Mutex
+	state  updateState
+	seen   map[string]bool
+	// confirmed is closed and replaced by every confirmation
+	confirmed chan struct{}
+}
+
+type updateState struct {
+	Offset int      `json:"offset"`
+	Keys   []string `json:"keys"`
+}
+
+// NewUpdateStore creates new UpdateStore instant and loads the state saved before
+func NewUpdateStore(path string, window int) (*UpdateStore, error) {
+	us := &UpdateStore{path: path, window: window, seen: make(map[string]bool), confirmed: make(chan struct{})}
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return us, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bytes, &us.state); err != nil {
+		return nil, fmt.Errorf("could not parse update store %s: %v", path, err)
+	}
+	for _, key := range us.state.Keys {
+		us.seen[key] = true
+	}
+	return us, nil
+}
+
+// Offset returns the ID of the first update which is not confirmed yet
+func (us *UpdateStore) Offset() int {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	return us.state.Offset
+}
+
+// Confirmed returns the offset and a channel which is closed by the next confirmation
+func (us *UpdateStore) Confirmed() (int, <-chan struct{}) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	return us.state.Offset, us.confirmed
+}
+
+// Seen reports whether the update or its message was already processed
+func (us *UpdateStore) Seen(update *tgbotapi.Update) bool {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	for _, key := range us.keys(update) {
+		if us.seen[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// Confirm marks the update as processed and persists the state
+func (us *UpdateStore) Confirm(update *tgbotapi.Update) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	for _, key := range us.keys(update) {
+		if !us.seen[key] {
+			us.seen[key] = true
+			us.state.Keys = append(us.state.Keys, key)
+		}
+	}
+	if len(us.state.Keys) > us.window {
+		for _, key := range us.state.Keys[:len(us.state.Keys)-us.window] {
+			delete(us.seen, key)
+		}
+		us.state.Keys = append([]string(nil), us.state.Keys[len(us.state.Keys)-us.window:]...)
+	}
+	if update.UpdateID >= us.state.Offset {
+		us.state.Offset = update.UpdateID + 1
+	}
+	close(us.confirmed)
+	us.confirmed = make(chan struct{})
+	return us.save()
+}
+
+func (us *UpdateStore) keys(update *tgbotapi.Update) []string {
+	keys := []string{fmt.Sprintf("u%d", update.UpdateID)}
+	if update.Message != nil && update.Message.Chat != nil {
+		keys = append(keys, fmt.Sprintf("m%d:%d", update.Message.Chat.ID, update.Message.MessageID))
+	}
+	return keys
+}
+
+func (us *UpdateStore) save() error {
+	bytes, err := json.Marshal(us.state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(us.path, bytes)
+}
+
+// writeFileAtomically writes to a temporary file first so a crash never leaves a truncated file
+func writeFileAtomically(path string, bytes []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(bytes); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestUpdateStoreConfirm(t *testing.T) {
+	dir, err := ioutil.TempDir("", "updates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewUpdateStore(filepath.Join(dir, "updates.json"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := &tgbotapi.Message{MessageID: 7, Chat: &tgbotapi.Chat{ID: 1}}
+	if err := store.Confirm(&tgbotapi.Update{UpdateID: 5, Message: message}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same message redelivered under a new update ID is skipped but still moves the offset
+	redelivered := &tgbotapi.Update{UpdateID: 6, Message: message}
+	if !store.Seen(redelivered) {
+		t.Fatal("the redelivered message is not seen")
+	}
+	_, confirmed := store.Confirmed()
+	if err := store.Confirm(redelivered); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-confirmed:
+	default:
+		t.Error("the confirmation is not signalled")
+	}
+	if offset, _ := store.Confirmed(); offset != 7 {
+		t.Errorf("offset = %d, want 7", offset)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// telegramNetworks are the ranges Telegram sends webhook requests from
+var telegramNetworks = []string{"149.154.160.0/20", "91.108.4.0/22"}
+
+// WebhookProperties holds all properties needed to receive updates via webhook
+type WebhookProperties struct {
+	PublicURL   string
+	BindAddress string
+	Port        string
+	CertFile    string
+	KeyFile     string
+	SelfSigned  bool
+	CheckIP     bool
+	TrustProxy  bool
+}
+
+// WebhookService receives updates from Telegram on a secret path
+type WebhookService struct {
+	properties *WebhookProperties
+	secretPath string
+	networks   []*net.IPNet
+	updates    chan tgbotapi.Update
+}
+
+// NewWebhookService creates new WebhookService instant with a random secret path
+func NewWebhookService(properties *WebhookProperties, buffer int) (*WebhookService, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	ws := &WebhookService{
+		properties: properties,
+		secretPath: "/" + hex.EncodeToString(secret),
+		updates:    make(chan tgbotapi.Update, buffer),
+	}
+	for _, cidr := range telegramNetworks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		ws.networks = append(ws.networks, network)
+	}
+	return ws, nil
+}
+
+// Address returns host:port the webhook server should listen on
+func (ws *WebhookService) Address() string {
+	bindAddress := ws.properties.BindAddress
+	if bindAddress == "" {
+		bindAddress = "0.0.0.0"
+	}
+	return net.JoinHostPort(bindAddress, ws.properties.Port)
+}
+
+// Register sets the webhook in Telegram and adds its handler to the mux
+func (ws *WebhookService) Register(bot *tgbotapi.BotAPI, mux *http.ServeMux) (tgbotapi.UpdatesChannel, error) {
+	bot.RemoveWebhook()
+	publicURL := strings.TrimSuffix(ws.properties.PublicURL, "/") + ws.secretPath
+	config := tgbotapi.NewWebhook(publicURL)
+	if ws.properties.SelfSigned && ws.properties.CertFile != "" {
+		config = tgbotapi.NewWebhookWithCert(publicURL, ws.properties.CertFile)
+	}
+	if _, err := bot.SetWebhook(config); err != nil {
+		return nil, err
+	}
+	mux.HandleFunc(ws.secretPath, ws.handle)
+	return ws.updates, nil
+}
+
+// Serve starts the server with TLS when a certificate/key pair is configured
+func (ws *WebhookService) Serve(server *http.Server) error {
+	if ws.properties.CertFile != "" && ws.properties.KeyFile != "" {
+		return server.ListenAndServeTLS(ws.properties.CertFile, ws.properties.KeyFile)
+	}
+	return server.ListenAndServe()
+}
+
+func (ws *WebhookService) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if ws.properties.CheckIP && !ws.isTelegramAddress(ws.sourceIP(r)) {
+		log.Printf("Rejected webhook request from %s", r.RemoteAddr)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	bytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var update tgbotapi.Update
+	if err := json.Unmarshal(bytes, &update); err != nil {
+		log.Printf("Could not decode webhook update: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ws.updates <- update
+}
+
+func (ws *WebhookService) sourceIP(r *http.Request) net.IP {
+	if ws.properties.TrustProxy {
+		// The last hop of X-Forwarded-For is added by the trusted proxy itself
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			return net.ParseIP(strings.TrimSpace(hops[len(hops)-1]))
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func (ws *WebhookService) isTelegramAddress(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range ws.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}